@@ -84,6 +84,8 @@ type ChartSpec struct {
 	Namespace  string        `json:"namespace,omitempty"`
 	Suffix     string        `json:"suffix,omitempty"`
 	ChartTgz   []byte        `json:"chart_tgz,omitempty"`
+	Atomic     bool          `json:"atomic,omitempty"`
+	Profile    string        `json:"profile,omitempty"`
 }
 
 // Operation defines the progress of the last operation
@@ -94,7 +96,8 @@ type Operation struct {
 
 // ChartStatus defines the observed state of ShalmChart
 type ChartStatus struct {
-	LastOp Operation `json:"lastOp,omitempty"`
+	LastOp  Operation   `json:"lastOp,omitempty"`
+	Outputs ClonableMap `json:"outputs,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -50,6 +50,7 @@ func (in *ChartSpec) DeepCopy() *ChartSpec {
 func (in *ChartStatus) DeepCopyInto(out *ChartStatus) {
 	*out = *in
 	out.LastOp = in.LastOp
+	in.Outputs.DeepCopyInto(&out.Outputs)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartStatus.
@@ -101,7 +102,7 @@ func (in *ShalmChart) DeepCopyInto(out *ShalmChart) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShalmChart.
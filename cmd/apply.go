@@ -1,34 +1,88 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
 	"github.com/kramerul/shalm/pkg/shalm"
 	"go.starlark.net/starlark"
+	"sigs.k8s.io/yaml"
 
 	"github.com/spf13/cobra"
 )
 
 var applyChartArgs = shalm.ChartOptions{}
+var applyK8sArgs = shalm.K8sConfigs{}
+var applyAtomic bool
+var applyOutput string
 
 var applyCmd = &cobra.Command{
 	Use:   "apply [chart]",
 	Short: "apply shalm chart",
-	Long:  ``,
+	Long:  `Prints NOTES.txt and the outputs of the chart after a successful apply`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		exit(apply(args[0], shalm.NewK8s(), applyChartArgs.Options()))
+		k8s, err := applyK8sArgs.K8s()
+		if err != nil {
+			exit(err)
+		}
+		exit(apply(args[0], k8s, os.Stdout, applyOutput, applyChartArgs.Options(), shalm.WithAtomic(applyAtomic)))
 	},
 }
 
-func apply(url string, k shalm.K8s, opts ...shalm.ChartOption) error {
+func apply(url string, k shalm.K8s, writer io.Writer, output string, opts ...shalm.ChartOption) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("Invalid output format %s. Only yaml and json are supported", output)
+	}
 	repo := shalm.NewRepo()
 	thread := &starlark.Thread{Name: "main"}
 	c, err := repo.Get(thread, url, opts...)
 	if err != nil {
 		return err
 	}
-	return c.Apply(thread, k)
+	if err := c.Apply(thread, k); err != nil {
+		return err
+	}
+	notes, err := c.Notes(thread)
+	if err != nil {
+		return err
+	}
+	if notes = strings.TrimSpace(notes); notes != "" {
+		if _, err := fmt.Fprintf(writer, "NOTES:\n%s\n", notes); err != nil {
+			return err
+		}
+	}
+	outputs, err := c.Outputs(thread)
+	if err != nil {
+		return err
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+	return writeOutputs(writer, output, outputs)
+}
+
+func writeOutputs(writer io.Writer, output string, outputs map[string]interface{}) error {
+	if output == "json" {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(outputs)
+	}
+	data, err := yaml.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
 }
 
 func init() {
 	applyChartArgs.AddFlags(applyCmd.Flags())
+	applyChartArgs.AddApplyFlags(applyCmd.Flags())
+	applyK8sArgs.AddFlags(applyCmd.Flags())
+	applyCmd.Flags().BoolVar(&applyAtomic, "atomic", false, "Restore the previous revision if apply fails")
+	applyCmd.Flags().StringVarP(&applyOutput, "output", "o", "yaml", "Output format of the chart outputs (yaml or json)")
 }
@@ -8,6 +8,7 @@ import (
 	"runtime"
 
 	"github.com/kramerul/shalm/pkg/shalm"
+	. "github.com/kramerul/shalm/pkg/shalm/test"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -36,18 +37,42 @@ var _ = Describe("Apply Chart", func() {
 			return k
 		}
 
-		err := apply(path.Join(example, "cf"), k, shalm.WithNamespace("mynamespace"))
+		err := apply(path.Join(example, "cf"), k, &bytes.Buffer{}, "yaml", shalm.WithNamespace("mynamespace"))
 		Expect(err).ToNot(HaveOccurred())
 		output := writer.String()
 		Expect(output).To(ContainSubstring("CREATE OR REPLACE USER 'uaa'"))
 		Expect(k.RolloutStatusCallCount()).To(Equal(1))
-		Expect(k.ApplyCallCount()).To(Equal(3))
-		Expect(k.ForNamespaceCallCount()).To(Equal(3))
+		Expect(k.ApplyCallCount()).To(Equal(5))
+		Expect(k.ForNamespaceCallCount()).To(Equal(6))
 		Expect(k.ForNamespaceArgsForCall(0)).To(Equal("mynamespace"))
 		Expect(k.ForNamespaceArgsForCall(1)).To(Equal("mynamespace"))
-		Expect(k.ForNamespaceArgsForCall(2)).To(Equal("uaa"))
+		Expect(k.ForNamespaceArgsForCall(2)).To(Equal("mynamespace"))
+		Expect(k.ForNamespaceArgsForCall(3)).To(Equal("uaa"))
 		kind, name, _ := k.RolloutStatusArgsForCall(0)
 		Expect(name).To(Equal("mariadb-master"))
 		Expect(kind).To(Equal("statefulset"))
 	})
+
+	It("prints notes and outputs", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("templates/NOTES.txt", []byte("Connect to {{ .Release.Name }} in {{ .Release.Namespace }}\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def outputs(self):\n  return {\"url\": \"http://\" + self.name}\n"), 0644)
+		k := &FakeK8s{}
+		k.ForNamespaceStub = func(s string) shalm.K8s {
+			return k
+		}
+		writer := bytes.Buffer{}
+		err := apply(dir.Root(), k, &writer, "yaml", shalm.WithNamespace("mynamespace"))
+		Expect(err).ToNot(HaveOccurred())
+		name := filepath.Base(dir.Root())
+		Expect(writer.String()).To(Equal("NOTES:\nConnect to " + name + " in mynamespace\nurl: http://" + name + "\n"))
+		writer.Reset()
+		err = apply(dir.Root(), k, &writer, "json", shalm.WithNamespace("mynamespace"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring("{\n  \"url\": \"http://" + name + "\"\n}\n"))
+		err = apply(dir.Root(), k, &writer, "xml")
+		Expect(err).To(HaveOccurred())
+	})
 })
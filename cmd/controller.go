@@ -47,7 +47,7 @@ func controller() error {
 		Scheme: mgr.GetScheme(),
 		Log:    reconcilerLog,
 		Repo:   shalm.NewRepo(),
-		K8s:    shalm.NewK8sFromContent,
+		K8s:    shalm.NewK8sNativeFromContent,
 	}
 	err = reconciler.SetupWithManager(mgr)
 	if err != nil {
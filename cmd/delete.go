@@ -8,6 +8,7 @@ import (
 )
 
 var deleteChartArgs = shalm.ChartOptions{}
+var deleteK8sArgs = shalm.K8sConfigs{}
 
 var deleteCmd = &cobra.Command{
 	Use:   "delete [chart]",
@@ -15,7 +16,11 @@ var deleteCmd = &cobra.Command{
 	Long:  ``,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		exit(delete(args[0], shalm.NewK8s(), deleteChartArgs.Options()))
+		k8s, err := deleteK8sArgs.K8s()
+		if err != nil {
+			exit(err)
+		}
+		exit(delete(args[0], k8s, deleteChartArgs.Options()))
 	},
 }
 
@@ -31,4 +36,5 @@ func delete(url string, k shalm.K8s, opts ...shalm.ChartOption) error {
 
 func init() {
 	deleteChartArgs.AddFlags(deleteCmd.Flags())
+	deleteK8sArgs.AddFlags(deleteCmd.Flags())
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+	"go.starlark.net/starlark"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of diff
+const (
+	diffExitChanged = 1
+	diffExitError   = 2
+)
+
+var diffChartArgs = shalm.ChartOptions{}
+var diffK8sArgs = shalm.K8sConfigs{}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [chart]",
+	Short: "show differences between shalm chart and kubernetes",
+	Long:  `Exits with code 1, if there are differences and with code 2, if an error occurred (like diff)`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		k8s, err := diffK8sArgs.K8s()
+		if err != nil {
+			exitWithCode(err, diffExitError)
+		}
+		changed, err := diff(args[0], k8s, os.Stdout, diffChartArgs.Options())
+		if err == nil && changed {
+			os.Exit(diffExitChanged)
+		}
+		exitWithCode(err, diffExitError)
+	},
+}
+
+func diff(url string, k shalm.K8s, writer io.Writer, opts ...shalm.ChartOption) (bool, error) {
+	repo := shalm.NewRepo()
+	thread := &starlark.Thread{Name: "main"}
+	c, err := repo.Get(thread, url, opts...)
+	if err != nil {
+		return false, err
+	}
+	return c.Diff(thread, k, writer)
+}
+
+func init() {
+	diffChartArgs.AddFlags(diffCmd.Flags())
+	diffChartArgs.AddApplyFlags(diffCmd.Flags())
+	diffK8sArgs.AddFlags(diffCmd.Flags())
+}
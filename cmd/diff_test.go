@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diff Chart", func() {
+
+	It("shows all objects as added", func() {
+		writer := &bytes.Buffer{}
+		k := &FakeK8s{
+			GetStub: func(kind string, name string, writer io.Writer, options *shalm.K8sOptions) error {
+				return errors.New("NotFound")
+			},
+			IsNotExistStub: func(err error) bool {
+				return true
+			},
+		}
+		k.ForNamespaceStub = func(s string) shalm.K8s {
+			return k
+		}
+		changed, err := diff(path.Join(example, "cf"), k, writer, shalm.WithNamespace("mynamespace"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		output := writer.String()
+		Expect(output).To(ContainSubstring("# StatefulSet/mynamespace/mariadb-master added"))
+		Expect(output).To(ContainSubstring("+++ rendered/StatefulSet/mynamespace/mariadb-master"))
+		Expect(output).To(ContainSubstring("+kind: StatefulSet"))
+	})
+})
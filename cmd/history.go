@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+
+	"github.com/spf13/cobra"
+)
+
+var historyNamespace string
+var historyK8sArgs = shalm.K8sConfigs{}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [name]",
+	Short: "show revisions of a release",
+	Long:  ``,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		k8s, err := historyK8sArgs.K8s()
+		if err != nil {
+			exit(err)
+		}
+		exit(history(args[0], k8s, historyNamespace, os.Stdout))
+	},
+}
+
+func history(name string, k shalm.K8s, namespace string, writer io.Writer) error {
+	return shalm.History(k, namespace, name, writer)
+}
+
+func init() {
+	historyCmd.Flags().StringVarP(&historyNamespace, "namespace", "n", "default", "Namespace of the release")
+	historyK8sArgs.AddFlags(historyCmd.Flags())
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+
+	It("lists all revisions", func() {
+		writer := &bytes.Buffer{}
+		k := &FakeK8s{
+			GetStub: func(kind string, name string, writer io.Writer, options *shalm.K8sOptions) error {
+				Expect(kind).To(Equal("secret"))
+				Expect(name).To(Equal("shalm.mariadb"))
+				writer.Write([]byte(`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "shalm.mariadb"},
+"data": {"inventory": "eyJvYmplY3RzIjpbXSwiaGlzdG9yeSI6W3sicmV2aXNpb24iOjEsImNoYXJ0IjoibWFyaWFkYiIsInZlcnNpb24iOiI2LjEyLjIiLCJ1cGRhdGVkIjoiMjAyMC0wMS0wMVQwMDowMDowMFoiLCJkZXNjcmlwdGlvbiI6Imluc3RhbGwifV19"}}`))
+				return nil
+			},
+		}
+		k.ForNamespaceStub = func(s string) shalm.K8s {
+			return k
+		}
+		err := history("mariadb", k, "mynamespace", writer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k.ForNamespaceArgsForCall(0)).To(Equal("mynamespace"))
+		Expect(writer.String()).To(ContainSubstring("REVISION"))
+		Expect(writer.String()).To(MatchRegexp(`1 +2020-01-01T00:00:00Z +mariadb +6.12.2 +install`))
+	})
+})
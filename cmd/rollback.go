@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+	"go.starlark.net/starlark"
+
+	"github.com/spf13/cobra"
+)
+
+var rollbackNamespace string
+var rollbackK8sArgs = shalm.K8sConfigs{}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [name] [revision]",
+	Short: "apply an old revision of a release",
+	Long:  `If no revision is given, the previous revision is applied`,
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision := 0
+		if len(args) == 2 {
+			var err error
+			revision, err = strconv.Atoi(args[1])
+			if err != nil {
+				exit(fmt.Errorf("Invalid revision %s", args[1]))
+			}
+		}
+		k8s, err := rollbackK8sArgs.K8s()
+		if err != nil {
+			exit(err)
+		}
+		exit(rollback(args[0], revision, k8s, rollbackNamespace))
+	},
+}
+
+func rollback(name string, revision int, k shalm.K8s, namespace string) error {
+	thread := &starlark.Thread{Name: "main"}
+	return shalm.Rollback(thread, shalm.NewRepo(), k, namespace, name, revision)
+}
+
+func init() {
+	rollbackCmd.Flags().StringVarP(&rollbackNamespace, "namespace", "n", "default", "Namespace of the release")
+	rollbackK8sArgs.AddFlags(rollbackCmd.Flags())
+}
@@ -14,7 +14,12 @@ func init() {
 	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(templateCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(rotateCmd)
 	rootCmd.AddCommand(packageCmd)
+	rootCmd.AddCommand(schemaCmd)
 	rootCmd.AddCommand(controllerCmd)
 	rootCmd.AddCommand(versionCmd)
 }
@@ -46,9 +51,14 @@ func unwrapEvalError(err error) error {
 }
 
 func exit(err error) {
+	exitWithCode(err, 1)
+}
+
+// exitWithCode exits with code, if err is not nil
+func exitWithCode(err error, code int) {
 	if err != nil {
 		fmt.Println(unwrapEvalError(err).Error())
-		os.Exit(1)
+		os.Exit(code)
 	}
 	os.Exit(0)
 }
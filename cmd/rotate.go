@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/kramerul/shalm/pkg/shalm"
+	"go.starlark.net/starlark"
+
+	"github.com/spf13/cobra"
+)
+
+var rotateChartArgs = shalm.ChartOptions{}
+var rotateK8sArgs = shalm.K8sConfigs{}
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate [chart] [credential]",
+	Short: "rotate user credentials of a shalm chart",
+	Long:  `If no credential is given, all user credentials of the chart are rotated. Credentials with a fixed password are skipped`,
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		k8s, err := rotateK8sArgs.K8s()
+		if err != nil {
+			exit(err)
+		}
+		exit(rotate(args[0], args[1:], k8s, rotateChartArgs.Options()))
+	},
+}
+
+func rotate(url string, credentials []string, k shalm.K8s, opts ...shalm.ChartOption) error {
+	repo := shalm.NewRepo()
+	thread := &starlark.Thread{Name: "main"}
+	c, err := repo.Get(thread, url, opts...)
+	if err != nil {
+		return err
+	}
+	return shalm.Rotate(thread, c, k, credentials...)
+}
+
+func init() {
+	rotateChartArgs.AddFlags(rotateCmd.Flags())
+	rotateChartArgs.AddApplyFlags(rotateCmd.Flags())
+	rotateK8sArgs.AddFlags(rotateCmd.Flags())
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/kramerul/shalm/pkg/shalm"
+
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema [chart]",
+	Short: "infer values.schema.json from values.yaml",
+	Long:  `The schema is written to stdout and can be used as starting point for values.schema.json`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exit(schema(args[0], os.Stdout))
+	},
+}
+
+func schema(url string, writer io.Writer) error {
+	dir, err := shalm.NewRepo().Directory(url)
+	if err != nil {
+		return err
+	}
+	return shalm.Schema(dir, writer)
+}
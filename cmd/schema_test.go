@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"bytes"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema", func() {
+
+	It("infers a schema from values.yaml", func() {
+		writer := &bytes.Buffer{}
+		err := schema(path.Join(example, "mariadb"), writer)
+		Expect(err).ToNot(HaveOccurred())
+		output := writer.String()
+		Expect(output).To(ContainSubstring(`"$schema": "http://json-schema.org/draft-07/schema#"`))
+		Expect(output).To(ContainSubstring(`"type": "object"`))
+	})
+})
@@ -67,7 +67,7 @@ func (r *ShalmChartReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 				return result, err
 			}
 		}
-		if err := r.apply(&shalmChart.Spec); err != nil {
+		if err := r.apply(&shalmChart.Spec, &shalmChart.Status); err != nil {
 			return result, err
 		}
 		shalmChart.Status.LastOp.Progress = 100
@@ -96,7 +96,7 @@ func (r *ShalmChartReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 
 }
 
-func (r *ShalmChartReconciler) apply(spec *shalmv1a1.ChartSpec) error {
+func (r *ShalmChartReconciler) apply(spec *shalmv1a1.ChartSpec, status *shalmv1a1.ChartStatus) error {
 	thread := &starlark.Thread{Name: "main"}
 	chart, err := r.Repo.GetFromSpec(thread, spec)
 	if err != nil {
@@ -106,7 +106,15 @@ func (r *ShalmChartReconciler) apply(spec *shalmv1a1.ChartSpec) error {
 	if err != nil {
 		return err
 	}
-	return chart.Apply(thread, k8s)
+	if err := chart.Apply(thread, k8s); err != nil {
+		return err
+	}
+	outputs, err := chart.Outputs(thread)
+	if err != nil {
+		return err
+	}
+	status.Outputs = shalmv1a1.ClonableMap(outputs)
+	return nil
 }
 
 func (r *ShalmChartReconciler) delete(spec *shalmv1a1.ChartSpec) error {
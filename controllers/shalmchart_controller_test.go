@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"github.com/kramerul/shalm/pkg/shalm"
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	"go.starlark.net/starlark"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -94,7 +96,49 @@ var _ = Describe("ShalmChartReconciler", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(buffer.String()).To(ContainSubstring("serviceName: mariadb-master"))
 		Expect(chart.ObjectMeta.Finalizers).To(ContainElement("controller.shalm.kramerul.github.com"))
-		Expect(k8s.ApplyCallCount()).To(Equal(1))
+		Expect(k8s.ApplyCallCount()).To(Equal(3))
+	})
+	It("stores outputs in status", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.WriteFile("Chart.yaml", []byte("name: test\nversion: 1.0.0\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def outputs(self):\n  return {\"url\": \"http://test\"}\n"), 0644)
+		thread := &starlark.Thread{Name: "main"}
+		c, err := shalm.NewRepo().Get(thread, dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		tgz := &bytes.Buffer{}
+		Expect(c.Package(tgz)).NotTo(HaveOccurred())
+
+		k8s := &FakeK8s{}
+		k8s.ForNamespaceStub = func(s string) shalm.K8s {
+			return k8s
+		}
+		chart := shalmv1a1.ShalmChart{
+			Spec: shalmv1a1.ChartSpec{
+				ChartTgz: tgz.Bytes(),
+			},
+		}
+		client := &FakeClient{
+			GetStub: func(ctx context.Context, name types.NamespacedName, object runtime.Object) error {
+				chart.DeepCopyInto(object.(*shalmv1a1.ShalmChart))
+				return nil
+			},
+			UpdateStub: func(ctx context.Context, object runtime.Object, options ...client.UpdateOption) error {
+				object.(*shalmv1a1.ShalmChart).DeepCopyInto(&chart)
+				return nil
+			},
+		}
+		reconciler := ShalmChartReconciler{
+			Client: client,
+			Log:    ctrl.Log.WithName("reconciler"),
+			Repo:   shalm.NewRepo(),
+			K8s: func(kubeconfig string) (shalm.K8s, error) {
+				return k8s, nil
+			},
+		}
+		_, err = reconciler.Reconcile(ctrl.Request{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(chart.Status.Outputs).To(HaveKeyWithValue("url", "http://test"))
 	})
 	It("deletes shalm chart correct", func() {
 
@@ -3,18 +3,25 @@ module github.com/kramerul/shalm
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.4.1
+	github.com/Masterminds/semver/v3 v3.0.1
 	github.com/Masterminds/sprig/v3 v3.0.0
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/go-logr/logr v0.1.0
 	github.com/k14s/ytt v0.22.0
 	github.com/k14s/ytt/pkg/yamlmeta/internal/yaml.v2 v0.0.0-20191211135110-6f8b8fe40a62 // indirect
 	github.com/maxbrunsfeld/counterfeiter/v6 v6.2.2
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/onsi/ginkgo v1.10.2
 	github.com/onsi/gomega v1.7.1
 	github.com/pkg/errors v0.8.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/pflag v1.0.5
+	github.com/xeipuuv/gojsonschema v1.2.0
 	go.starlark.net v0.0.0-20191021185836-28350e608555
+	golang.org/x/crypto v0.0.0-20190911031432-227b76d455e7
 	golang.org/x/sys v0.0.0-20191010194322-b09406accb47 // indirect
 	golang.org/x/tools v0.0.0-20190911151314-feee8acb394c // indirect
 	gopkg.in/yaml.v2 v2.2.4
@@ -22,5 +29,5 @@ require (
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.0.0-20190918160344-1fbdaa4c8d90
 	sigs.k8s.io/controller-runtime v0.4.0
-
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -30,7 +30,12 @@ type Chart interface {
 	Apply(thread *starlark.Thread, k K8s) error
 	Delete(thread *starlark.Thread, k K8s) error
 	Template(thread *starlark.Thread) (string, error)
+	Diff(thread *starlark.Thread, k K8s, writer io.Writer) (bool, error)
 	Package(writer io.Writer) error
+	// Notes renders templates/NOTES.txt
+	Notes(thread *starlark.Thread) (string, error)
+	// Outputs returns the result of outputs(self) defined in Chart.star
+	Outputs(thread *starlark.Thread) (map[string]interface{}, error)
 }
 
 // ChartValue -
@@ -41,8 +46,10 @@ type ChartValue interface {
 
 // K8sOptions common options for calls to k8s
 type K8sOptions struct {
-	Namespaced bool
-	Timeout    time.Duration
+	Namespaced     bool
+	Timeout        time.Duration
+	FieldManager   string
+	ForceConflicts bool
 }
 
 // K8s kubernetes API
@@ -68,12 +75,25 @@ type K8sValue interface {
 
 // ChartOptions -
 type ChartOptions struct {
-	namespace string
-	suffix    string
-	proxy     bool
-	args      starlark.Tuple
-	kwargs    []starlark.Tuple
-	cmdArgs   []string
+	namespace  string
+	suffix     string
+	proxy      bool
+	prune      bool
+	atomic     bool
+	historyMax int
+	profile    string
+	global     map[string]interface{}
+	args       starlark.Tuple
+	kwargs     []starlark.Tuple
+	values     []map[string]interface{}
+	cmdArgs    []string
+	stringArgs []string
+	fileArgs   []string
+	valueFiles []string
+	valueArgs  []string
+	// applyFlags is set by AddApplyFlags. Otherwise prune and historyMax keep their defaults
+	applyFlags bool
+	err        error
 }
 
 // ChartOption -
@@ -94,6 +114,31 @@ func WithProxy(proxy bool) ChartOption {
 	return func(options *ChartOptions) { options.proxy = proxy }
 }
 
+// WithPrune -
+func WithPrune(prune bool) ChartOption {
+	return func(options *ChartOptions) { options.prune = prune }
+}
+
+// WithHistoryMax limits the number of stored revisions. 0 means no limit
+func WithHistoryMax(historyMax int) ChartOption {
+	return func(options *ChartOptions) { options.historyMax = historyMax }
+}
+
+// WithAtomic -
+func WithAtomic(atomic bool) ChartOption {
+	return func(options *ChartOptions) { options.atomic = atomic }
+}
+
+// WithProfile -
+func WithProfile(profile string) ChartOption {
+	return func(options *ChartOptions) { options.profile = profile }
+}
+
+// WithGlobal merges global values of the parent chart into values.global
+func WithGlobal(global map[string]interface{}) ChartOption {
+	return func(options *ChartOptions) { options.global = global }
+}
+
 // WithArgs -
 func WithArgs(args starlark.Tuple) ChartOption {
 	return func(options *ChartOptions) { options.args = args }
@@ -104,10 +149,21 @@ func WithKwArgs(kwargs []starlark.Tuple) ChartOption {
 	return func(options *ChartOptions) { options.kwargs = kwargs }
 }
 
+// WithValues merges values into the values of the chart before init. Values of sub charts are merged after init
+func WithValues(values map[string]interface{}) ChartOption {
+	return func(options *ChartOptions) { options.values = append(options.values, values) }
+}
+
 // Repo -
 type Repo interface {
 	// Get -
 	Get(thread *starlark.Thread, url string, options ...ChartOption) (ChartValue, error)
 	// GetFromSpec -
 	GetFromSpec(thread *starlark.Thread, spec *shalmv1a1.ChartSpec) (ChartValue, error)
+	// Directory returns the local directory of a chart
+	Directory(url string) (string, error)
+	// Module returns the local directory of a chart, which is used to load its modules. The directory is never removed
+	Module(url string) (string, error)
+	// File returns the local path of a file, which is downloaded if url is an http(s) url. Downloads are revalidated on each call
+	File(url string) (string, error)
 }
@@ -0,0 +1,100 @@
+package shalm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"go.starlark.net/starlark"
+)
+
+const createdKey = "shalm.created"
+
+// createdObjects records all objects, which didn't exist before they were applied
+type createdObjects struct {
+	objects []objectRef
+}
+
+// trackCreated enables recording of created objects for thread. The returned function disables it again
+func trackCreated(thread *starlark.Thread) (*createdObjects, func()) {
+	created := &createdObjects{}
+	thread.SetLocal(createdKey, created)
+	return created, func() { thread.SetLocal(createdKey, nil) }
+}
+
+// recordCreated records all objects in content, which don't exist yet. It does nothing, if tracking isn't enabled
+func recordCreated(thread *starlark.Thread, k K8s, content []byte) error {
+	created, ok := thread.Local(createdKey).(*createdObjects)
+	if !ok || created == nil {
+		return nil
+	}
+	objects, err := objectRefs(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		err := k.ForNamespace(o.Namespace).Get(kindArg(o.APIVersion, o.Kind), o.Name, ioutil.Discard, &K8sOptions{Namespaced: o.Namespace != ""})
+		if err == nil {
+			continue
+		}
+		if !k.IsNotExist(err) {
+			return err
+		}
+		created.objects = append(created.objects, o)
+	}
+	return nil
+}
+
+// restore is called after a failed apply. It applies the objects of the last revision again and deletes all objects,
+// which were created by the failed apply
+func (c *chartImpl) restore(thread *starlark.Thread, k K8s, inv *inventory, created *createdObjects, cause error) error {
+	_, err := starlark.Call(thread, starlark.NewBuiltin("restore", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+		return starlark.None, c.restoreRevision(thread, k, inv, created)
+	}), nil, nil)
+	if err != nil {
+		return fmt.Errorf("%s (rollback failed: %s)", cause.Error(), err.Error())
+	}
+	return fmt.Errorf("%s (rolled back to revision %d)", cause.Error(), inv.lastRevision())
+}
+
+func (c *chartImpl) restoreRevision(thread *starlark.Thread, k K8s, inv *inventory, created *createdObjects) error {
+	if revision := inv.lastRevision(); revision > 0 {
+		spec, err := readRevision(k.ForNamespace(c.namespace), c.GetName(), revision)
+		if err != nil {
+			return err
+		}
+		value, err := c.repo.GetFromSpec(thread, spec)
+		if err != nil {
+			return err
+		}
+		previous, ok := value.(*chartImpl)
+		if !ok {
+			return fmt.Errorf("revision %d of %s can't be restored", revision, c.GetName())
+		}
+		previous.setRevision(revision)
+		if err := previous.getOrCreateCredentials(k); err != nil {
+			return err
+		}
+		var buffer bytes.Buffer
+		if err := previous.templateRecursive(thread, &buffer, &renderer.Options{SeparateHooks: true}); err != nil {
+			return err
+		}
+		err = k.Apply(func(writer io.Writer) error {
+			_, err := writer.Write(buffer.Bytes())
+			return err
+		}, &K8sOptions{FieldManager: c.fieldManager()})
+		if err != nil {
+			return err
+		}
+	}
+	// Only objects, which didn't exist before the failed apply, are deleted
+	orphans := (&inventory{Objects: created.objects}).without(inv.Objects)
+	for i := len(orphans) - 1; i >= 0; i-- {
+		if err := orphans[i].delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
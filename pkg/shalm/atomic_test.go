@@ -0,0 +1,87 @@
+package shalm
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Atomic apply", func() {
+	var dir TestDir
+	var k *k8sNativeImpl
+	thread := &starlark.Thread{Name: "main"}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def init(self, fail=False):
+  self.fail = fail
+def apply(self, k8s):
+  self.__apply(k8s)
+  if self.fail:
+    fail("rollout failed")
+`), 0644)
+		dir.WriteFile("templates/cm1.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm1\ndata:\n  key: v1\n"), 0644)
+		k = newFakeK8sNative()
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	apply := func(fail bool) error {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"), WithAtomic(true),
+			WithKwArgs([]starlark.Tuple{{starlark.String("fail"), starlark.Bool(fail)}}))
+		Expect(err).NotTo(HaveOccurred())
+		return c.Apply(thread, k)
+	}
+
+	get := func(name string) (string, error) {
+		writer := &bytes.Buffer{}
+		err := k.Get("configmap", name, writer, &K8sOptions{Namespaced: true})
+		return writer.String(), err
+	}
+
+	It("restores the previous revision", func() {
+		Expect(apply(false)).NotTo(HaveOccurred())
+		dir.WriteFile("templates/cm1.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm1\ndata:\n  key: v2\n"), 0644)
+		dir.WriteFile("templates/cm2.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm2\n"), 0644)
+		err := apply(true)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("rolled back to revision 1"))
+		cm1, err := get("cm1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm1).To(ContainSubstring(`"key":"v1"`))
+		_, err = get("cm2")
+		Expect(k.IsNotExist(err)).To(BeTrue())
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.lastRevision()).To(Equal(1))
+	})
+
+	It("deletes all objects on a failed install", func() {
+		err := apply(true)
+		Expect(err).To(HaveOccurred())
+		_, err = get("cm1")
+		Expect(k.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("keeps existing objects on a failed install", func() {
+		Expect(k.Apply(func(writer io.Writer) error {
+			_, err := writer.Write([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm1\n  namespace: namespace\n"))
+			return err
+		}, &K8sOptions{})).NotTo(HaveOccurred())
+		dir.WriteFile("templates/cm2.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm2\n"), 0644)
+		err := apply(true)
+		Expect(err).To(HaveOccurred())
+		_, err = get("cm1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = get("cm2")
+		Expect(k.IsNotExist(err)).To(BeTrue())
+	})
+})
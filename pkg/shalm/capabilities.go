@@ -0,0 +1,81 @@
+package shalm
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/discovery"
+)
+
+// capabilitiesDiscoverer is implemented by K8s implementations, which can read the capabilities of the cluster
+type capabilitiesDiscoverer interface {
+	Capabilities() (*renderer.Capabilities, error)
+}
+
+// capabilities returns the capabilities of the cluster, which are passed as .Capabilities to helm templates.
+// If k can't discover them, nil is returned and the default capabilities are used like in shalm template
+func capabilities(k K8s) (*renderer.Capabilities, error) {
+	if v, ok := k.(*k8sValueImpl); ok {
+		k = v.K8s
+	}
+	if d, ok := k.(capabilitiesDiscoverer); ok {
+		return d.Capabilities()
+	}
+	return nil, nil
+}
+
+// capabilitiesCache discovers the capabilities of a cluster only once. It's shared by all copies created with ForNamespace
+type capabilitiesCache struct {
+	once         sync.Once
+	capabilities *renderer.Capabilities
+	err          error
+}
+
+func (c *capabilitiesCache) get(newClient func() (discovery.DiscoveryInterface, error)) (*renderer.Capabilities, error) {
+	if c == nil {
+		client, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		return discoverCapabilities(client)
+	}
+	c.once.Do(func() {
+		client, err := newClient()
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.capabilities, c.err = discoverCapabilities(client)
+	})
+	return c.capabilities, c.err
+}
+
+func discoverCapabilities(client discovery.DiscoveryInterface) (*renderer.Capabilities, error) {
+	version, err := client.ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "error discovering kubernetes version")
+	}
+	// Like helm, use all group versions, which could be discovered
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "error discovering api versions")
+	}
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		seen[list.GroupVersion] = true
+		for _, resource := range list.APIResources {
+			seen[list.GroupVersion+"/"+resource.Kind] = true
+		}
+	}
+	versions := make(renderer.VersionSet, 0, len(seen))
+	for v := range seen {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return &renderer.Capabilities{
+		KubeVersion: renderer.KubeVersion{Version: version.GitVersion, Major: version.Major, Minor: version.Minor},
+		APIVersions: versions,
+	}, nil
+}
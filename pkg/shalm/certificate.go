@@ -0,0 +1,299 @@
+package shalm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	caCertKey         = "ca.crt"
+	defaultValidity   = 365
+	certificateKeyLen = 2048
+)
+
+type certificate struct {
+	name         string
+	ca           *certificate
+	isCA         bool
+	commonName   string
+	dnsNames     []string
+	validityDays int
+	cert         []byte
+	key          []byte
+	caCert       []byte
+	loaded       bool
+}
+
+var (
+	_ credential = (*certificate)(nil)
+)
+
+func makeCertificate(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+	s := &certificate{validityDays: defaultValidity}
+	var ca starlark.Value = starlark.None
+	dnsNames := &starlark.List{}
+	if err := starlark.UnpackArgs("certificate", args, kwargs, "name", &s.name,
+		"ca?", &ca, "is_ca?", &s.isCA, "common_name?", &s.commonName,
+		"dns_names?", &dnsNames, "validity_days?", &s.validityDays); err != nil {
+		return starlark.None, err
+	}
+	if ca != starlark.None {
+		c, ok := ca.(*certificate)
+		if !ok {
+			return starlark.None, fmt.Errorf("certificate: ca must be a certificate, got %s", ca.Type())
+		}
+		s.ca = c
+	}
+	for i := 0; i < dnsNames.Len(); i++ {
+		name, ok := dnsNames.Index(i).(starlark.String)
+		if !ok {
+			return starlark.None, fmt.Errorf("certificate: dns_names must be a list of strings")
+		}
+		s.dnsNames = append(s.dnsNames, name.GoString())
+	}
+	if s.commonName == "" {
+		s.commonName = s.name
+	}
+	if s.validityDays <= 0 {
+		return starlark.None, fmt.Errorf("certificate: validity_days must be positive")
+	}
+	return s, nil
+}
+
+// String -
+func (c *certificate) String() string {
+	buf := new(strings.Builder)
+	buf.WriteString("certificate")
+	buf.WriteByte('(')
+	buf.WriteString("name = ")
+	buf.WriteString(c.name)
+	buf.WriteString(", common_name = ")
+	buf.WriteString(c.commonName)
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// GetOrCreate reads the certificate from kubernetes. A new certificate is created, if it doesn't exist,
+// is close to expiry or isn't signed by the current ca
+func (c *certificate) GetOrCreate(k8s K8s) error {
+	if c.loaded {
+		return nil
+	}
+	if c.ca != nil {
+		if err := c.ca.GetOrCreate(k8s); err != nil {
+			return err
+		}
+	}
+	var buffer bytes.Buffer
+	err := k8s.Get("secret", c.name, &buffer, &K8sOptions{Namespaced: true})
+	if err != nil {
+		if !k8s.IsNotExist(err) {
+			return err
+		}
+	} else {
+		var secret corev1.Secret
+		if _, _, err = serializer.Decode(buffer.Bytes(), nil, &secret); err != nil {
+			return err
+		}
+		c.cert = secret.Data[corev1.TLSCertKey]
+		c.key = secret.Data[corev1.TLSPrivateKeyKey]
+		c.caCert = secret.Data[caCertKey]
+	}
+	if !c.valid(time.Now()) {
+		if err := c.generate(time.Now()); err != nil {
+			return err
+		}
+	}
+	c.loaded = true
+	return nil
+}
+
+// valid checks, if the current certificate can be used further
+func (c *certificate) valid(now time.Time) bool {
+	cert, err := parseCertificate(c.cert)
+	if err != nil || len(c.key) == 0 {
+		return false
+	}
+	renewBefore := time.Duration(c.validityDays) * 24 * time.Hour / 3
+	if now.Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	if cert.Subject.CommonName != c.commonName || !sameNames(cert.DNSNames, c.dnsNames) {
+		return false
+	}
+	if c.ca == nil {
+		return true
+	}
+	if !bytes.Equal(c.caCert, c.ca.cert) {
+		return false
+	}
+	caCert, err := parseCertificate(c.ca.cert)
+	if err != nil {
+		return false
+	}
+	return cert.CheckSignatureFrom(caCert) == nil
+}
+
+// sameNames compares two lists of names ignoring their order
+func sameNames(names []string, other []string) bool {
+	if len(names) != len(other) {
+		return false
+	}
+	sorted := append([]string{}, names...)
+	sortedOther := append([]string{}, other...)
+	sort.Strings(sorted)
+	sort.Strings(sortedOther)
+	for i := range sorted {
+		if sorted[i] != sortedOther[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *certificate) generate(now time.Time) error {
+	key, err := rsa.GenerateKey(rand.Reader, certificateKeyLen)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: c.commonName},
+		DNSNames:              c.dnsNames,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Duration(c.validityDays) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	if c.isCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	parent := template
+	var signer interface{} = key
+	if c.ca != nil {
+		parent, err = parseCertificate(c.ca.cert)
+		if err != nil {
+			return err
+		}
+		signer, err = parsePrivateKey(c.ca.key)
+		if err != nil {
+			return err
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return err
+	}
+	c.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	c.key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if c.ca != nil {
+		c.caCert = c.ca.cert
+	} else {
+		c.caCert = c.cert
+	}
+	return nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func (c *certificate) secret(namespace string) *corev1.Secret {
+	data := map[string][]byte{}
+	if len(c.cert) != 0 {
+		data[corev1.TLSCertKey] = c.cert
+	}
+	if len(c.key) != 0 {
+		data[corev1.TLSPrivateKeyKey] = c.key
+	}
+	if len(c.caCert) != 0 {
+		data[caCertKey] = c.caCert
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: data,
+	}
+}
+
+// Type -
+func (c *certificate) Type() string { return "certificate" }
+
+// Freeze -
+func (c *certificate) Freeze() {}
+
+// Truth -
+func (c *certificate) Truth() starlark.Bool { return false }
+
+// Hash -
+func (c *certificate) Hash() (uint32, error) { return 0, fmt.Errorf("certificate is unhashable") }
+
+// Attr -
+func (c *certificate) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		return starlark.String(c.name), nil
+	case "cert":
+		if len(c.cert) == 0 {
+			return nil, errors.New("cert is only available after certificate is applied")
+		}
+		return starlark.String(c.cert), nil
+	case "key":
+		if len(c.key) == 0 {
+			return nil, errors.New("key is only available after certificate is applied")
+		}
+		return starlark.String(c.key), nil
+	case "ca":
+		if len(c.caCert) == 0 {
+			return nil, errors.New("ca is only available after certificate is applied")
+		}
+		return starlark.String(c.caCert), nil
+	default:
+		return starlark.None, starlark.NoSuchAttrError(fmt.Sprintf("certificate has no .%s attribute", name))
+	}
+}
+
+// AttrNames -
+func (c *certificate) AttrNames() []string {
+	return []string{"name", "cert", "key", "ca"}
+}
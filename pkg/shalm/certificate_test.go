@@ -0,0 +1,100 @@
+package shalm
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("certificate", func() {
+	thread := &starlark.Thread{Name: "main"}
+
+	It("creates a ca and a server certificate", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def init(self):
+  self.ca = certificate("ca", is_ca=True)
+  self.tls = certificate("server", ca=self.ca, dns_names=["mariadb.namespace.svc"], validity_days=10)
+`), 0644)
+		k := newFakeK8sNative()
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+
+		var buffer bytes.Buffer
+		Expect(k.Get("secret", "server", &buffer, &K8sOptions{Namespaced: true})).NotTo(HaveOccurred())
+		var secret map[string]interface{}
+		Expect(json.Unmarshal(buffer.Bytes(), &secret)).NotTo(HaveOccurred())
+		Expect(secret["type"]).To(Equal("kubernetes.io/tls"))
+
+		server := c.values["tls"].(*certificate)
+		ca := c.values["ca"].(*certificate)
+		cert, err := parseCertificate(server.cert)
+		Expect(err).NotTo(HaveOccurred())
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(ca.cert)).To(BeTrue())
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "mariadb.namespace.svc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.caCert).To(Equal(ca.cert))
+
+		By("reusing existing certificates")
+		c, err = newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(c.values["tls"].(*certificate).cert).To(Equal(server.cert))
+		value, err := c.values["tls"].(*certificate).Attr("key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(starlark.String(server.key)))
+	})
+
+	It("renews certificates close to expiry", func() {
+		c := &certificate{name: "test", commonName: "test", validityDays: 30}
+		Expect(c.generate(time.Now())).NotTo(HaveOccurred())
+		Expect(c.valid(time.Now())).To(BeTrue())
+		Expect(c.valid(time.Now().Add(25 * 24 * time.Hour))).To(BeFalse())
+	})
+
+	It("renews certificates, if the names change", func() {
+		c := &certificate{name: "test", commonName: "test", dnsNames: []string{"a.svc", "b.svc"}, validityDays: 30}
+		Expect(c.generate(time.Now())).NotTo(HaveOccurred())
+		Expect(c.valid(time.Now())).To(BeTrue())
+		c.dnsNames = []string{"b.svc", "a.svc"}
+		Expect(c.valid(time.Now())).To(BeTrue())
+		c.dnsNames = []string{"a.svc", "b.svc", "c.svc"}
+		Expect(c.valid(time.Now())).To(BeFalse())
+		c.dnsNames = []string{"a.svc", "b.svc"}
+		c.commonName = "other"
+		Expect(c.valid(time.Now())).To(BeFalse())
+	})
+
+	It("renews certificates, if the ca changes", func() {
+		ca := &certificate{name: "ca", commonName: "ca", isCA: true, validityDays: 30}
+		Expect(ca.generate(time.Now())).NotTo(HaveOccurred())
+		c := &certificate{name: "test", commonName: "test", ca: ca, validityDays: 30}
+		Expect(c.generate(time.Now())).NotTo(HaveOccurred())
+		Expect(c.valid(time.Now())).To(BeTrue())
+		Expect(ca.generate(time.Now())).NotTo(HaveOccurred())
+		Expect(c.valid(time.Now())).To(BeFalse())
+	})
+
+	It("validates arguments", func() {
+		_, err := starlark.Call(thread, starlark.NewBuiltin("certificate", makeCertificate), starlark.Tuple{starlark.String("test")},
+			[]starlark.Tuple{{starlark.String("ca"), starlark.String("invalid")}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is unhashable", func() {
+		value, err := starlark.Call(thread, starlark.NewBuiltin("certificate", makeCertificate), starlark.Tuple{starlark.String("test")}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = value.Hash()
+		Expect(err).To(MatchError("certificate is unhashable"))
+	})
+})
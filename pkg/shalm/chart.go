@@ -3,6 +3,7 @@ package shalm
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -13,21 +14,34 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"github.com/xeipuuv/gojsonschema"
 	"go.starlark.net/starlark"
 )
 
 type chartImpl struct {
-	clazz           chartClass
-	Version         semver.Version
-	values          starlark.StringDict
-	methods         map[string]starlark.Callable
-	dir             string
-	namespace       string
-	suffix          string
-	userCredentials []*userCredential
+	clazz         chartClass
+	Version       semver.Version
+	values        starlark.StringDict
+	methods       map[string]starlark.Callable
+	dir           string
+	namespace     string
+	suffix        string
+	prune         bool
+	atomic        bool
+	historyMax    int
+	profile       string
+	repo          Repo
+	args          starlark.Tuple
+	kwargs        []starlark.Tuple
+	revision      int
+	credentials   []credential
+	loadedModules map[string]string
+	rendered      []objectRef
+	schema        *gojsonschema.Schema
 }
 
 var (
@@ -37,9 +51,13 @@ var (
 func newChart(thread *starlark.Thread, repo Repo, dir string, opts ...ChartOption) (*chartImpl, error) {
 	name := strings.Split(filepath.Base(dir), ":")[0]
 	co := chartOptions(opts)
-	c := &chartImpl{dir: dir, namespace: co.namespace, suffix: co.suffix, clazz: chartClass{Name: name}}
+	if co.err != nil {
+		return nil, co.err
+	}
+	c := &chartImpl{dir: dir, namespace: co.namespace, suffix: co.suffix, prune: co.prune, atomic: co.atomic, historyMax: co.historyMax, profile: co.profile, repo: repo, args: co.args, kwargs: co.kwargs, revision: 1, clazz: chartClass{Name: name}}
 	c.values = make(map[string]starlark.Value)
 	c.methods = make(map[string]starlark.Callable)
+	c.loadedModules = make(map[string]string)
 	if err := c.loadChartYaml(); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
@@ -50,9 +68,38 @@ func newChart(thread *starlark.Thread, repo Repo, dir string, opts ...ChartOptio
 			return nil, err
 		}
 	}
+	if err := c.loadProfileValuesYaml(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := c.loadSchema(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for _, values := range co.values {
+		if err := c.mergeValues(values); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.mergeGlobal(co.global); err != nil {
+		return nil, err
+	}
+	if err := c.loadDependencies(thread, repo); err != nil {
+		return nil, err
+	}
 	if err := c.init(thread, repo, co.args, co.kwargs); err != nil {
 		return nil, err
 	}
+	for _, values := range co.values {
+		if err := c.mergeSubChartValues(values); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.validateValues(); err != nil {
+		return nil, err
+	}
 	return c, nil
 
 }
@@ -145,9 +192,19 @@ func (c *chartImpl) Attr(name string) (starlark.Value, error) {
 	if name == "name" {
 		return starlark.String(c.GetName()), nil
 	}
+	if name == "profile" {
+		return starlark.String(c.profile), nil
+	}
 	if name == "__class__" {
 		return &c.clazz, nil
 	}
+	// global is a reserved word in starlark. Therefore values.global is available as globals
+	if name == "globals" {
+		if _, ok := c.values["global"]; !ok {
+			c.values["global"] = starlark.NewDict(0)
+		}
+		name = "global"
+	}
 	value, ok := c.values[name]
 	if !ok {
 		var m starlark.Value
@@ -177,6 +234,9 @@ func (c *chartImpl) AttrNames() []string {
 
 // SetField -
 func (c *chartImpl) SetField(name string, val starlark.Value) error {
+	if name == "globals" {
+		name = "global"
+	}
 	c.values[name] = unwrapDict(val)
 	return nil
 }
@@ -192,14 +252,61 @@ func (c *chartImpl) applyFunction() starlark.Callable {
 }
 
 func (c *chartImpl) Apply(thread *starlark.Thread, k K8s) error {
-	_, err := starlark.Call(thread, c.methods["apply"], starlark.Tuple{NewK8sValue(k)}, nil)
+	return c.applyRelease(thread, k, "")
+}
+
+// applyRelease applies the chart as new revision of the release
+func (c *chartImpl) applyRelease(thread *starlark.Thread, k K8s, description string) error {
+	inv, err := readInventory(k.ForNamespace(c.namespace), c.GetName())
 	if err != nil {
 		return err
 	}
-	return nil
+	revision := inv.lastRevision() + 1
+	c.setRevision(revision)
+	c.resetRendered()
+	var created *createdObjects
+	if c.atomic {
+		var untrack func()
+		created, untrack = trackCreated(thread)
+		defer untrack()
+	}
+	_, err = starlark.Call(thread, c.methods["apply"], starlark.Tuple{NewK8sValue(k)}, nil)
+	if err != nil {
+		if c.atomic {
+			return c.restore(thread, k, inv, created, err)
+		}
+		return err
+	}
+	if err = c.writeRevision(k.ForNamespace(c.namespace), revision); err != nil {
+		return err
+	}
+	if description == "" {
+		description = "install"
+		if revision > 1 {
+			description = "upgrade"
+		}
+	}
+	inv.History = append(inv.History, revisionInfo{
+		Revision:    revision,
+		Chart:       c.clazz.Name,
+		Version:     c.Version.String(),
+		Updated:     time.Now().UTC(),
+		Description: description,
+	})
+	pruned := inv.pruneHistory(c.historyMax)
+	if err := c.updateInventory(k, inv); err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	return c.deleteRevisions(k.ForNamespace(c.namespace), pruned)
 }
 
 func (c *chartImpl) apply(thread *starlark.Thread, k K8sValue) error {
+	if err := c.callHook(thread, "pre_apply", k); err != nil {
+		return err
+	}
 	err := c.eachSubChart(func(subChart *chartImpl) error {
 		_, err := subChart.methods["apply"].CallInternal(thread, starlark.Tuple{k}, nil)
 		return err
@@ -207,7 +314,20 @@ func (c *chartImpl) apply(thread *starlark.Thread, k K8sValue) error {
 	if err != nil {
 		return err
 	}
-	return c.applyLocal(thread, k, &K8sOptions{}, &renderer.Options{})
+	if err := c.applyLocal(thread, k, &K8sOptions{}, &renderer.Options{}, c.template); err != nil {
+		return err
+	}
+	return c.callHook(thread, "post_apply", k)
+}
+
+// callHook calls the lifecycle hook name (e.g. pre_apply), if it's defined in Chart.star
+func (c *chartImpl) callHook(thread *starlark.Thread, name string, k K8sValue) error {
+	hook, ok := c.methods[name]
+	if !ok {
+		return nil
+	}
+	_, err := hook.CallInternal(thread, starlark.Tuple{k}, nil)
+	return err
 }
 
 func (c *chartImpl) applyLocalFunction() starlark.Callable {
@@ -216,33 +336,89 @@ func (c *chartImpl) applyLocalFunction() starlark.Callable {
 		parser := &kwargsParser{kwargs: kwargs}
 		rendererOptionss := unpackRendererOptions(parser)
 		k8sOptions := unpackK8sOptions(parser)
+		parser.Arg("force_conflicts", func(value starlark.Value) {
+			k8sOptions.ForceConflicts = bool(value.Truth())
+		})
+		manifest := unpackManifestArgs(parser)
 		if err := starlark.UnpackArgs("__apply", args, parser.Parse(), "k8s", &k); err != nil {
 			return nil, err
 		}
-		return starlark.None, c.applyLocal(thread, k, k8sOptions, rendererOptionss)
+		template, err := c.templater(manifest, rendererOptionss)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.None, c.applyLocal(thread, k, k8sOptions, rendererOptionss, template)
 	})
 }
 
-func (c *chartImpl) applyLocal(thread *starlark.Thread, k K8sValue, k8sOptions *K8sOptions, rendererOptions *renderer.Options) error {
-	for _, credential := range c.userCredentials {
+func (c *chartImpl) applyLocal(thread *starlark.Thread, k K8sValue, k8sOptions *K8sOptions, rendererOptions *renderer.Options, template templater) error {
+	for _, credential := range c.credentials {
 		err := credential.GetOrCreate(k)
 		if err != nil {
 			return err
 		}
 	}
 	k8sOptions.Namespaced = false
-	return k.Apply(func(writer io.Writer) error {
-		return c.template(thread, writer, rendererOptions)
+	k8sOptions.FieldManager = c.fieldManager()
+	rendererOptions.SeparateHooks = true
+	rendererOptions.Lookup = lookup(k)
+	var err error
+	if rendererOptions.Capabilities, err = capabilities(k); err != nil {
+		return err
+	}
+	buffer, err := c.render(thread, rendererOptions, template)
+	if err != nil {
+		return err
+	}
+	pre, post := c.applyHookEvents()
+	if err := c.runHooks(k, rendererOptions.Hooks, pre, k8sOptions); err != nil {
+		return err
+	}
+	if err := recordCreated(thread, k, buffer); err != nil {
+		return err
+	}
+	err = k.Apply(func(writer io.Writer) error {
+		_, err := writer.Write(buffer)
+		return err
 	}, k8sOptions)
+	if err != nil {
+		return err
+	}
+	return c.runHooks(k, rendererOptions.Hooks, post, k8sOptions)
+}
+
+// render templates the chart and records the rendered objects
+func (c *chartImpl) render(thread *starlark.Thread, rendererOptions *renderer.Options, template templater) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := template(thread, &buffer, rendererOptions); err != nil {
+		return nil, err
+	}
+	objects, err := objectRefs(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	c.rendered = append(c.rendered, objects...)
+	return buffer.Bytes(), nil
+}
+
+func (c *chartImpl) fieldManager() string {
+	return "shalm/" + c.GetName()
 }
 
 func (c *chartImpl) Delete(thread *starlark.Thread, k K8s) error {
-	_, err := starlark.Call(thread, c.methods["delete"], starlark.Tuple{NewK8sValue(k)}, nil)
+	inv, err := readInventory(k.ForNamespace(c.namespace), c.GetName())
 	if err != nil {
 		return err
 	}
-	return nil
-
+	if revision := inv.lastRevision(); revision > 0 {
+		c.setRevision(revision)
+	}
+	c.resetRendered()
+	_, err = starlark.Call(thread, c.methods["delete"], starlark.Tuple{NewK8sValue(k)}, nil)
+	if err != nil {
+		return err
+	}
+	return c.deleteInventory(k, inv)
 }
 
 func (c *chartImpl) deleteFunction() starlark.Callable {
@@ -256,6 +432,9 @@ func (c *chartImpl) deleteFunction() starlark.Callable {
 }
 
 func (c *chartImpl) delete(thread *starlark.Thread, k K8sValue) error {
+	if err := c.callHook(thread, "pre_delete", k); err != nil {
+		return err
+	}
 	err := c.eachSubChart(func(subChart *chartImpl) error {
 		_, err := subChart.methods["delete"].CallInternal(thread, starlark.Tuple{k}, nil)
 		return err
@@ -263,7 +442,10 @@ func (c *chartImpl) delete(thread *starlark.Thread, k K8sValue) error {
 	if err != nil {
 		return err
 	}
-	return c.deleteLocal(thread, k, &K8sOptions{}, &renderer.Options{})
+	if err := c.deleteLocal(thread, k, &K8sOptions{}, &renderer.Options{}, c.template); err != nil {
+		return err
+	}
+	return c.callHook(thread, "post_delete", k)
 }
 
 func (c *chartImpl) deleteLocalFunction() starlark.Callable {
@@ -272,19 +454,42 @@ func (c *chartImpl) deleteLocalFunction() starlark.Callable {
 		parser := &kwargsParser{kwargs: kwargs}
 		rendererOptionss := unpackRendererOptions(parser)
 		k8sOptions := unpackK8sOptions(parser)
+		manifest := unpackManifestArgs(parser)
 		if err := starlark.UnpackArgs("__delete", args, parser.Parse(), "k8s", &k); err != nil {
 			return nil, err
 		}
-		return starlark.None, c.deleteLocal(thread, k, k8sOptions, rendererOptionss)
+		template, err := c.templater(manifest, rendererOptionss)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.None, c.deleteLocal(thread, k, k8sOptions, rendererOptionss, template)
 	})
 }
 
-func (c *chartImpl) deleteLocal(thread *starlark.Thread, k K8sValue, k8sOptions *K8sOptions, rendererOptions *renderer.Options) error {
+func (c *chartImpl) deleteLocal(thread *starlark.Thread, k K8sValue, k8sOptions *K8sOptions, rendererOptions *renderer.Options, template templater) error {
 	rendererOptions.UninstallOrder = true
+	rendererOptions.SeparateHooks = true
+	rendererOptions.Lookup = lookup(k)
+	var err error
+	if rendererOptions.Capabilities, err = capabilities(k); err != nil {
+		return err
+	}
 	k8sOptions.Namespaced = false
-	return k.Delete(func(writer io.Writer) error {
-		return c.template(thread, writer, rendererOptions)
+	buffer, err := c.render(thread, rendererOptions, template)
+	if err != nil {
+		return err
+	}
+	if err := c.runHooks(k, rendererOptions.Hooks, hookPreDelete, k8sOptions); err != nil {
+		return err
+	}
+	err = k.Delete(func(writer io.Writer) error {
+		_, err := writer.Write(buffer)
+		return err
 	}, k8sOptions)
+	if err != nil {
+		return err
+	}
+	return c.runHooks(k, rendererOptions.Hooks, hookPostDelete, k8sOptions)
 }
 
 func (c *chartImpl) eachSubChart(block func(subChart *chartImpl) error) error {
@@ -300,10 +505,44 @@ func (c *chartImpl) eachSubChart(block func(subChart *chartImpl) error) error {
 	return nil
 }
 
-func (c *chartImpl) mergeValues(values map[string]interface{}) {
+func (c *chartImpl) mergeValues(values map[string]interface{}) error {
 	for k, v := range values {
-		c.values[k] = merge(c.values[k], toStarlark(v))
+		value, err := merge(c.values[k], toStarlark(v))
+		if err != nil {
+			return at(k, err)
+		}
+		c.values[k] = value
 	}
+	return nil
+}
+
+// mergeGlobal merges the global values of the parent chart into values.global. Global values of the parent chart win
+func (c *chartImpl) mergeGlobal(global map[string]interface{}) error {
+	if len(global) == 0 {
+		return nil
+	}
+	return c.mergeValues(map[string]interface{}{"global": global})
+}
+
+// global returns values.global, which is inherited by sub charts
+func (c *chartImpl) global() map[string]interface{} {
+	global, _ := toGo(c.values["global"]).(map[string]interface{})
+	return global
+}
+
+// mergeSubChartValues merges values into sub charts, which are created during init
+func (c *chartImpl) mergeSubChartValues(values map[string]interface{}) error {
+	for k, v := range values {
+		if subChart, ok := c.values[k].(*chartImpl); ok {
+			if _, err := merge(subChart, toStarlark(v)); err != nil {
+				return at(k, err)
+			}
+			if err := subChart.validateValues(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func unpackRendererOptions(parser *kwargsParser) *renderer.Options {
@@ -319,7 +558,7 @@ func (c *chartImpl) Package(writer io.Writer) error {
 	defer gz.Close()
 	tw := tar.NewWriter(gz)
 	defer tw.Close()
-	return c.walk(func(file string, size int64, body io.Reader, err error) error {
+	write := func(file string, size int64, body io.Reader, err error) error {
 		hdr := &tar.Header{
 			Name: path.Join(c.clazz.Name, file),
 			Mode: 0644,
@@ -332,7 +571,28 @@ func (c *chartImpl) Package(writer io.Writer) error {
 			return err
 		}
 		return nil
-	})
+	}
+	if err := c.walk(write); err != nil {
+		return err
+	}
+	for file, name := range c.loadedModules {
+		err := func() error {
+			body, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+			info, err := body.Stat()
+			if err != nil {
+				return err
+			}
+			return write(path.Join(loadDir, name), info.Size(), body, nil)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 var chartDirExpr = regexp.MustCompile("^[^/]*/")
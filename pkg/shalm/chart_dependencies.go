@@ -0,0 +1,249 @@
+package shalm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"go.starlark.net/starlark"
+	"gopkg.in/yaml.v2"
+)
+
+// dependency is an entry of dependencies in Chart.yaml or requirements.yaml
+type dependency struct {
+	Name         string        `yaml:"name"`
+	Version      string        `yaml:"version"`
+	Repository   string        `yaml:"repository"`
+	Condition    string        `yaml:"condition"`
+	Tags         []string      `yaml:"tags"`
+	Alias        string        `yaml:"alias"`
+	ImportValues []interface{} `yaml:"import-values"`
+}
+
+type repoIndex struct {
+	Entries map[string][]struct {
+		Version string   `yaml:"version"`
+		URLs    []string `yaml:"urls"`
+	} `yaml:"entries"`
+}
+
+// attributeName returns the name of the chart attribute and the values key of the dependency
+func (d *dependency) attributeName() string {
+	if d.Alias != "" {
+		return d.Alias
+	}
+	return d.Name
+}
+
+// enabled evaluates condition and tags like helm does. A condition, which resolves to a bool, overrides tags
+func (d *dependency) enabled(values starlark.StringDict) bool {
+	for _, condition := range strings.Split(d.Condition, ",") {
+		if condition = strings.TrimSpace(condition); condition == "" {
+			continue
+		}
+		if enabled, ok := valuePath(values, condition).(starlark.Bool); ok {
+			return bool(enabled)
+		}
+	}
+	found := false
+	for _, tag := range d.Tags {
+		if enabled, ok := valuePath(values, "tags."+tag).(starlark.Bool); ok {
+			if enabled {
+				return true
+			}
+			found = true
+		}
+	}
+	return !found
+}
+
+// readDependencies reads the dependencies from Chart.yaml and requirements.yaml
+func (c *chartImpl) readDependencies() ([]dependency, error) {
+	var result []dependency
+	for _, file := range []string{"Chart.yaml", "requirements.yaml"} {
+		var deps struct {
+			Dependencies []dependency `yaml:"dependencies"`
+		}
+		if err := c.loadYamlFile(c.path(file), &deps); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, deps.Dependencies...)
+	}
+	return result, nil
+}
+
+// loadDependencies loads all enabled dependencies as sub charts. The values of the parent under the name of
+// the dependency are passed to the sub chart
+func (c *chartImpl) loadDependencies(thread *starlark.Thread, repo Repo) error {
+	deps, err := c.readDependencies()
+	if err != nil {
+		return err
+	}
+	for _, d := range deps {
+		if !d.enabled(c.values) {
+			continue
+		}
+		url, err := c.dependencyURL(repo, &d)
+		if err != nil {
+			return err
+		}
+		name := d.attributeName()
+		co := ChartOptions{namespace: c.namespace, suffix: c.suffix, profile: c.profile, global: c.global()}
+		if values, ok := toGo(c.values[name]).(map[string]interface{}); ok {
+			co.values = []map[string]interface{}{values}
+		}
+		value, err := repo.Get(thread, url, co.Options())
+		if err != nil {
+			return fmt.Errorf("Unable to load dependency %s of chart %s: %s", d.Name, c.GetName(), err.Error())
+		}
+		subChart, ok := value.(*chartImpl)
+		if !ok {
+			return fmt.Errorf("Invalid dependency %s of chart %s", d.Name, c.GetName())
+		}
+		if d.Alias != "" {
+			subChart.clazz.Name = d.Alias
+		}
+		c.values[name] = subChart
+		if err := c.importValues(&d, subChart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencyURL looks for the dependency inside the charts directory first. Afterwards the repository is used
+func (c *chartImpl) dependencyURL(repo Repo, d *dependency) (string, error) {
+	if stat, err := os.Stat(c.path("charts", d.Name)); err == nil && stat.IsDir() {
+		return c.path("charts", d.Name), nil
+	}
+	constraint, err := versionConstraint(d.Version)
+	if err != nil {
+		return "", fmt.Errorf("Invalid version %s of dependency %s: %s", d.Version, d.Name, err.Error())
+	}
+	archives, err := filepath.Glob(c.path("charts", d.Name+"-*.tgz"))
+	if err != nil {
+		return "", err
+	}
+	for _, archive := range archives {
+		version, err := semver.NewVersion(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(archive), d.Name+"-"), ".tgz"))
+		if err == nil && constraint.Check(version) {
+			return archive, nil
+		}
+	}
+	switch {
+	case d.Repository == "":
+		return "", fmt.Errorf("Dependency %s of chart %s not found in charts directory", d.Name, c.GetName())
+	case strings.HasPrefix(d.Repository, "file://"):
+		dir := strings.TrimPrefix(d.Repository, "file://")
+		if !filepath.IsAbs(dir) {
+			dir = c.path(dir)
+		}
+		return dir, nil
+	case strings.HasPrefix(d.Repository, "http:") || strings.HasPrefix(d.Repository, "https:"):
+		return resolveFromRepository(repo, d.Repository, d.Name, constraint)
+	default:
+		return "", fmt.Errorf("Repository %s of dependency %s is not supported. Only URLs are supported", d.Repository, d.Name)
+	}
+}
+
+// versionConstraint parses version with the constraint syntax of helm (e.g. ^1.2, ~1.2.3, 1.x, 1.0 - 2.0)
+func versionConstraint(version string) (*semver.Constraints, error) {
+	if version == "" {
+		version = "*"
+	}
+	return semver.NewConstraint(version)
+}
+
+// resolveFromRepository returns the URL of the latest chart version inside a helm repository, which matches constraint
+func resolveFromRepository(repo Repo, repository string, name string, constraint *semver.Constraints) (string, error) {
+	repository = strings.TrimSuffix(repository, "/")
+	file, err := repo.File(repository + "/index.yaml")
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var index repoIndex
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("Invalid index.yaml of repository %s: %s", repository, err.Error())
+	}
+	var latest *semver.Version
+	var url string
+	for _, entry := range index.Entries[name] {
+		version, err := semver.NewVersion(entry.Version)
+		if err != nil || len(entry.URLs) == 0 || !constraint.Check(version) {
+			continue
+		}
+		if latest == nil || version.GreaterThan(latest) {
+			latest = version
+			url = entry.URLs[0]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("Chart %s matching version %s not found in repository %s", name, constraint.String(), repository)
+	}
+	if !(strings.HasPrefix(url, "http:") || strings.HasPrefix(url, "https:")) {
+		url = repository + "/" + url
+	}
+	return url, nil
+}
+
+// importValues copies values from the sub chart to the parent. Entries are either the name of a key below
+// exports or a dict with child and parent path
+func (c *chartImpl) importValues(d *dependency, subChart *chartImpl) error {
+	for _, importValue := range d.ImportValues {
+		var child, parent string
+		switch v := importValue.(type) {
+		case string:
+			child = "exports." + v
+		case map[interface{}]interface{}:
+			child, _ = v["child"].(string)
+			parent, _ = v["parent"].(string)
+		default:
+			return fmt.Errorf("Invalid import-values of dependency %s: %v", d.Name, importValue)
+		}
+		value, ok := toGo(valuePath(subChart.values, child)).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var values map[string]interface{}
+		if parent == "" || parent == "." {
+			values = value
+		} else {
+			values = make(map[string]interface{})
+			setPath(values, strings.Split(parent, "."), value)
+		}
+		if err := c.mergeValues(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valuePath returns the value addressed by a dot separated path or nil
+func valuePath(values starlark.StringDict, p string) starlark.Value {
+	keys := strings.Split(p, ".")
+	value, ok := values[keys[0]]
+	if !ok {
+		return nil
+	}
+	for _, key := range keys[1:] {
+		mapping, ok := value.(starlark.Mapping)
+		if !ok {
+			return nil
+		}
+		value, ok, _ = mapping.Get(starlark.String(key))
+		if !ok {
+			return nil
+		}
+	}
+	return value
+}
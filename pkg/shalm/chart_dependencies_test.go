@@ -0,0 +1,152 @@
+package shalm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Chart dependencies", func() {
+
+	var thread *starlark.Thread
+	var dir TestDir
+
+	writeChart := func(p string, name string, values string) {
+		dir.MkdirAll(path.Join(p, "templates"), 0755)
+		dir.WriteFile(path.Join(p, "Chart.yaml"), []byte("name: "+name+"\nversion: 1.2.3\n"), 0644)
+		dir.WriteFile(path.Join(p, "values.yaml"), []byte(values), 0644)
+		dir.WriteFile(path.Join(p, "templates", "configmap.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Chart.Name }}
+data:
+  key: {{ .Values.key }}
+`), 0644)
+	}
+
+	BeforeEach(func() {
+		thread = &starlark.Thread{Name: "main"}
+		dir = NewTestDir()
+	})
+
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("loads dependencies from charts directory", func() {
+		dir.WriteFile("Chart.yaml", []byte(`name: parent
+version: 1.0.0
+dependencies:
+- name: sub
+  version: ~1.2.0
+- name: sub
+  alias: other
+  version: 1.2.3
+- name: sub
+  alias: disabled
+  condition: disabled.enabled
+`), 0644)
+		dir.WriteFile("values.yaml", []byte("sub:\n  key: parent\ndisabled:\n  enabled: false\n"), 0644)
+		writeChart("charts/sub", "sub", "key: sub\n")
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithValues(map[string]interface{}{"other": map[string]interface{}{"key": "override"}}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["sub"]).To(BeAssignableToTypeOf(&chartImpl{}))
+		Expect(c.values["other"]).To(BeAssignableToTypeOf(&chartImpl{}))
+		Expect(c.values["disabled"]).NotTo(BeAssignableToTypeOf(&chartImpl{}))
+		value, err := c.Attr("sub")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value.(*chartImpl).values["key"]).To(Equal(starlark.String("parent")))
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("name: sub"))
+		Expect(output).To(ContainSubstring("key: parent"))
+		Expect(output).To(ContainSubstring("name: other"))
+		Expect(output).To(ContainSubstring("key: override"))
+		Expect(output).NotTo(ContainSubstring("name: disabled"))
+	})
+
+	It("honours tags", func() {
+		dir.WriteFile("requirements.yaml", []byte(`dependencies:
+- name: sub
+  tags: [backend]
+- name: sub
+  alias: frontend
+  tags: [frontend, backend]
+- name: sub
+  alias: forced
+  condition: forced.enabled
+  tags: [backend]
+`), 0644)
+		dir.WriteFile("values.yaml", []byte("tags:\n  backend: false\n  frontend: true\nforced:\n  enabled: true\n"), 0644)
+		writeChart("charts/sub", "sub", "key: sub\n")
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["sub"]).NotTo(BeAssignableToTypeOf(&chartImpl{}))
+		Expect(c.values["frontend"]).To(BeAssignableToTypeOf(&chartImpl{}))
+		Expect(c.values["forced"]).To(BeAssignableToTypeOf(&chartImpl{}))
+	})
+
+	It("imports values", func() {
+		dir.MkdirAll("parent", 0755)
+		dir.WriteFile("parent/Chart.yaml", []byte(`name: parent
+version: 1.0.0
+dependencies:
+- name: sub
+  repository: file://../sub
+  import-values:
+  - data
+  - child: config
+    parent: imported
+`), 0644)
+		dir.WriteFile("parent/values.yaml", []byte("imported:\n  keep: true\n"), 0644)
+		writeChart("sub", "sub", "exports:\n  data:\n    port: 3306\nconfig:\n  user: admin\n")
+		c, err := newChart(thread, NewRepo(), dir.Join("parent"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toGo(c.values["port"])).To(Equal(int64(3306)))
+		Expect(toGo(c.values["imported"])).To(Equal(map[string]interface{}{"keep": true, "user": "admin"}))
+	})
+
+	It("loads dependencies from a helm repository", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/index.yaml":
+				w.Write([]byte(`entries:
+  mariadb:
+  - version: 6.12.2
+    urls: [mariadb-6.12.2.tgz]
+  - version: 7.0.0
+    urls: [mariadb-7.0.0.tgz]
+`))
+			case "/mariadb-6.12.2.tgz":
+				content, _ := ioutil.ReadFile(path.Join(example, "mariadb-6.12.2.tgz"))
+				w.Write(content)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		dir.WriteFile("Chart.yaml", []byte(`name: parent
+version: 1.0.0
+dependencies:
+- name: mariadb
+  version: ^6.0.0
+  repository: `+server.URL+`
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["mariadb"]).To(BeAssignableToTypeOf(&chartImpl{}))
+		Expect(c.values["mariadb"].(*chartImpl).Version.String()).To(Equal("6.12.2"))
+	})
+
+	It("fails for missing dependencies", func() {
+		dir.WriteFile("Chart.yaml", []byte("name: parent\nversion: 1.0.0\ndependencies:\n- name: missing\n"), 0644)
+		_, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).To(MatchError(ContainSubstring("Dependency missing of chart parent not found in charts directory")))
+	})
+})
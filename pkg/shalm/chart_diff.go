@@ -0,0 +1,239 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"github.com/pmezard/go-difflib/difflib"
+	"go.starlark.net/starlark"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// Diff writes a unified diff between the rendered chart and the objects inside kubernetes. It returns true, if there are differences
+func (c *chartImpl) Diff(thread *starlark.Thread, k K8s, writer io.Writer) (bool, error) {
+	changed := false
+	_, err := starlark.Call(thread, starlark.NewBuiltin("diff", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+		var err error
+		changed, err = c.diff(thread, k, writer)
+		return starlark.None, err
+	}), nil, nil)
+	return changed, err
+}
+
+func (c *chartImpl) diff(thread *starlark.Thread, k K8s, writer io.Writer) (bool, error) {
+	if err := c.getOrCreateCredentials(k); err != nil {
+		return false, err
+	}
+	inv, err := readInventory(k.ForNamespace(c.namespace), c.GetName())
+	if err != nil {
+		return false, err
+	}
+	c.setRevision(inv.lastRevision() + 1)
+	caps, err := capabilities(k)
+	if err != nil {
+		return false, err
+	}
+	var buffer bytes.Buffer
+	if err := c.templateRecursive(thread, &buffer, &renderer.Options{SeparateHooks: true, Lookup: lookup(k), Capabilities: caps}); err != nil {
+		return false, err
+	}
+	objects, err := decodeObjects(&buffer)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, obj := range objects {
+		live, err := getObject(k, obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		if err != nil {
+			if !k.IsNotExist(err) {
+				return false, err
+			}
+			live = nil
+		} else {
+			live = project(live, obj.Object).(map[string]interface{})
+		}
+		d, err := diffObjects(objectName(obj.GetKind(), obj.GetNamespace(), obj.GetName()), live, obj.Object)
+		if err != nil {
+			return false, err
+		}
+		if d != "" {
+			changed = true
+			if _, err := writer.Write([]byte(d)); err != nil {
+				return false, err
+			}
+		}
+	}
+	if !c.prune {
+		return changed, nil
+	}
+	refs, err := objectRefs(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		return false, err
+	}
+	for _, ref := range inv.without(refs) {
+		live, err := getObject(k, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name)
+		if err != nil {
+			if k.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+		d, err := diffObjects(ref.name(), live, nil)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+		if _, err := writer.Write([]byte(d)); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+func (c *chartImpl) getOrCreateCredentials(k K8s) error {
+	err := c.eachSubChart(func(subChart *chartImpl) error {
+		return subChart.getOrCreateCredentials(k)
+	})
+	if err != nil {
+		return err
+	}
+	for _, credential := range c.credentials {
+		if err := credential.GetOrCreate(k.ForNamespace(c.namespace)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeObjects(reader io.Reader) ([]*unstructured.Unstructured, error) {
+	var result []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		var obj map[string]interface{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		result = append(result, &unstructured.Unstructured{Object: obj})
+	}
+	return result, nil
+}
+
+func getObject(k K8s, apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+	var buffer bytes.Buffer
+	err := k.ForNamespace(namespace).Get(kindArg(apiVersion, kind), name, &buffer, &K8sOptions{Namespaced: namespace != ""})
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// kindArg builds a kind argument like 'Deployment.v1.apps', which is understood by kubectl
+func kindArg(apiVersion string, kind string) string {
+	parts := strings.SplitN(apiVersion, "/", 2)
+	if len(parts) != 2 {
+		return kind
+	}
+	return kind + "." + parts[1] + "." + parts[0]
+}
+
+func objectName(kind string, namespace string, name string) string {
+	if namespace == "" {
+		return kind + "/" + name
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// project removes all fields from live, which are not part of desired (e.g. status or defaulted values)
+func project(live interface{}, desired interface{}) interface{} {
+	switch desired := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		result := make(map[string]interface{})
+		for k, v := range desired {
+			if lv, found := l[k]; found {
+				result[k] = project(lv, v)
+			}
+		}
+		return result
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		result := make([]interface{}, len(l))
+		for i, lv := range l {
+			if i < len(desired) {
+				result[i] = project(lv, desired[i])
+			} else {
+				result[i] = lv
+			}
+		}
+		return result
+	default:
+		return live
+	}
+}
+
+func diffObjects(name string, live map[string]interface{}, desired map[string]interface{}) (string, error) {
+	var a []string
+	fromFile := "/dev/null"
+	if live != nil {
+		data, err := k8syaml.Marshal(live)
+		if err != nil {
+			return "", err
+		}
+		a = difflib.SplitLines(string(data))
+		fromFile = "live/" + name
+	}
+	var b []string
+	toFile := "/dev/null"
+	if desired != nil {
+		data, err := k8syaml.Marshal(desired)
+		if err != nil {
+			return "", err
+		}
+		b = difflib.SplitLines(string(data))
+		toFile = "rendered/" + name
+	}
+	d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	if err != nil {
+		return "", err
+	}
+	if d == "" {
+		return "", nil
+	}
+	switch {
+	case live == nil:
+		return fmt.Sprintf("# %s added\n%s", name, d), nil
+	case desired == nil:
+		return fmt.Sprintf("# %s removed\n%s", name, d), nil
+	default:
+		return fmt.Sprintf("# %s changed\n%s", name, d), nil
+	}
+}
@@ -0,0 +1,67 @@
+package shalm
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Chart diff", func() {
+	var dir TestDir
+	var c ChartValue
+	var k *FakeK8s
+	var live string
+	thread := &starlark.Thread{Name: "main"}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\ndata:\n  key: value\n"), 0644)
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		var err error
+		c, err = newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		k = &FakeK8s{
+			GetStub: func(kind string, name string, writer io.Writer, options *K8sOptions) error {
+				if kind == "secret" {
+					return nil
+				}
+				Expect(kind).To(Equal("ConfigMap"))
+				Expect(name).To(Equal("test"))
+				writer.Write([]byte(live))
+				return nil
+			},
+		}
+		k.ForNamespaceStub = func(s string) K8s {
+			return k
+		}
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("ignores fields, which are not rendered", func() {
+		live = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test", "namespace": "namespace", "uid": "1234"}, "data": {"key": "value"}}`
+		writer := &bytes.Buffer{}
+		changed, err := c.Diff(thread, k, writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(writer.String()).To(BeEmpty())
+	})
+
+	It("shows changed fields", func() {
+		live = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test", "namespace": "namespace"}, "data": {"key": "old"}}`
+		writer := &bytes.Buffer{}
+		changed, err := c.Diff(thread, k, writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(writer.String()).To(ContainSubstring("# ConfigMap/namespace/test changed"))
+		Expect(writer.String()).To(ContainSubstring("--- live/ConfigMap/namespace/test"))
+		Expect(writer.String()).To(ContainSubstring("-  key: old"))
+		Expect(writer.String()).To(ContainSubstring("+  key: value"))
+	})
+})
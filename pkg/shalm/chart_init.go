@@ -13,7 +13,6 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"go.starlark.net/starlark"
-	"go.starlark.net/starlarkstruct"
 )
 
 func (c *chartImpl) loadChartYaml() error {
@@ -48,14 +47,41 @@ func (c *chartImpl) loadValuesYaml() error {
 	return nil
 }
 
+// starFiles returns Chart.star followed by the overlay Chart.<profile>.star
+func (c *chartImpl) starFiles() []string {
+	files := []string{c.path("Chart.star")}
+	if c.profile != "" {
+		files = append(files, c.path(fmt.Sprintf("Chart.%s.star", c.profile)))
+	}
+	return files
+}
+
+// loadProfileValuesYaml merges values-<profile>.yaml on top of values.yaml
+func (c *chartImpl) loadProfileValuesYaml() error {
+	if c.profile == "" {
+		return nil
+	}
+	var values map[string]interface{}
+	err := c.loadYamlFile(c.path(fmt.Sprintf("values-%s.yaml", c.profile)), &values)
+	if err != nil {
+		return err
+	}
+	return c.mergeValues(values)
+}
+
 func (c *chartImpl) init(thread *starlark.Thread, repo Repo, args starlark.Tuple, kwargs []starlark.Tuple) error {
 	c.methods["apply"] = c.applyFunction()
 	c.methods["delete"] = c.deleteFunction()
 	c.methods["__apply"] = c.applyLocalFunction()
 	c.methods["__delete"] = c.deleteLocalFunction()
 
-	file := c.path("Chart.star")
-	if _, err := os.Stat(file); os.IsNotExist(err) {
+	var files []string
+	for _, file := range c.starFiles() {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
 		return nil
 	}
 
@@ -68,7 +94,7 @@ func (c *chartImpl) init(thread *starlark.Thread, repo Repo, args starlark.Tuple
 			if !(filepath.IsAbs(url) || strings.HasPrefix(url, "http")) {
 				url = path.Join(c.dir, url)
 			}
-			co := ChartOptions{namespace: c.namespace, suffix: c.suffix}
+			co := ChartOptions{namespace: c.namespace, suffix: c.suffix, profile: c.profile, global: c.global()}
 			parser := &kwargsParser{kwargs: kwargs}
 			parser.Arg("namespace", func(value starlark.Value) {
 				co.namespace = value.(starlark.String).GoString()
@@ -79,7 +105,17 @@ func (c *chartImpl) init(thread *starlark.Thread, repo Repo, args starlark.Tuple
 			parser.Arg("suffix", func(value starlark.Value) {
 				co.suffix = value.(starlark.String).GoString()
 			})
+			var err error
+			parser.Arg("globals", func(value starlark.Value) {
+				var global starlark.Value
+				if global, err = merge(toStarlark(co.global), value); err == nil {
+					co.global, _ = toGo(global).(map[string]interface{})
+				}
+			})
 			co.kwargs = parser.Parse()
+			if err != nil {
+				return starlark.None, at("globals", err)
+			}
 			return repo.Get(thread, url, co.Options())
 		}),
 		"user_credential": starlark.NewBuiltin("user_credential", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
@@ -87,26 +123,58 @@ func (c *chartImpl) init(thread *starlark.Thread, repo Repo, args starlark.Tuple
 			if err != nil {
 				return s, err
 			}
-			c.userCredentials = append(c.userCredentials, s.(*userCredential))
+			c.credentials = append(c.credentials, s.(*userCredential))
 			return s, nil
 		}),
-		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"certificate": starlark.NewBuiltin("certificate", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+			s, err := makeCertificate(thread, fn, args, kwargs)
+			if err != nil {
+				return s, err
+			}
+			c.credentials = append(c.credentials, s.(*certificate))
+			return s, nil
+		}),
+		"secret": starlark.NewBuiltin("secret", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+			s, err := makeGenericSecret(thread, fn, args, kwargs)
+			if err != nil {
+				return s, err
+			}
+			c.credentials = append(c.credentials, s.(*genericSecret))
+			return s, nil
+		}),
+		"struct": starlark.NewBuiltin("struct", makeStruct),
 		"k8s": starlark.NewBuiltin("k8s", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
 			return makeK8sValue(thread, fn, args, kwargs, c.namespace)
 		}),
 	}
-	globals, err := starlark.ExecFile(thread, file, nil, internal)
-	if err != nil {
-		return err
+	for k, v := range stdlib() {
+		internal[k] = v
 	}
-
-	init, ok := globals["init"]
-
-	if ok {
-		_, err := starlark.Call(thread, init, append([]starlark.Value{c}, args...), kwargs)
+	for k, v := range secretGenerators() {
+		internal[k] = v
+	}
+	globals := starlark.StringDict{}
+	for _, file := range files {
+		g, err := c.execFile(thread, file, c.dir, "", internal)
 		if err != nil {
 			return err
 		}
+		init, ok := g["init"]
+		if ok {
+			// Only Chart.star receives args and kwargs. The init of an overlay is called afterwards with self only
+			initArgs := starlark.Tuple{c}
+			initKwargs := []starlark.Tuple(nil)
+			if file == c.path("Chart.star") {
+				initArgs = append(initArgs, args...)
+				initKwargs = kwargs
+			}
+			if _, err := starlark.Call(thread, init, initArgs, initKwargs); err != nil {
+				return err
+			}
+		}
+		for k, v := range g {
+			globals[k] = v
+		}
 	}
 
 	for k, v := range globals {
@@ -125,13 +193,14 @@ func (c *chartImpl) init(thread *starlark.Thread, repo Repo, args starlark.Tuple
 			})
 		}
 	}
-	c.methods["apply"] = wrapNamespace(c.methods["apply"], c.namespace)
-	c.methods["delete"] = wrapNamespace(c.methods["delete"], c.namespace)
+	c.methods["apply"] = wrapNamespace(c.methods["apply"], c)
+	c.methods["delete"] = wrapNamespace(c.methods["delete"], c)
 
 	return nil
 }
 
-func wrapNamespace(callable starlark.Callable, namespace string) starlark.Callable {
+// wrapNamespace passes k8s for the namespace of the chart to callable. Objects applied by k8s.apply belong to the chart
+func wrapNamespace(callable starlark.Callable, c *chartImpl) starlark.Callable {
 	return starlark.NewBuiltin(callable.Name(), func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
 		if len(args) == 0 {
 			return nil, fmt.Errorf("Missing first argument k8s")
@@ -140,7 +209,7 @@ func wrapNamespace(callable starlark.Callable, namespace string) starlark.Callab
 		if !ok {
 			return nil, fmt.Errorf("Invalid first argument to %s", callable.Name())
 		}
-		args[0] = &k8sValueImpl{k.ForNamespace(namespace)}
+		args[0] = &k8sValueImpl{K8s: k.ForNamespace(c.namespace), chart: c}
 		return callable.CallInternal(thread, args, kwargs)
 	})
 }
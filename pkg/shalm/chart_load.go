@@ -0,0 +1,120 @@
+package shalm
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// loadDir contains all modules of other charts, which are loaded by a packaged chart
+const loadDir = ".load"
+
+type loadKey struct {
+	chart *chartImpl
+	file  string
+}
+
+type loadEntry struct {
+	globals starlark.StringDict
+	err     error
+}
+
+func loadCache(thread *starlark.Thread) map[loadKey]*loadEntry {
+	cache, ok := thread.Local("shalm.load").(map[loadKey]*loadEntry)
+	if !ok {
+		cache = make(map[loadKey]*loadEntry)
+		thread.SetLocal("shalm.load", cache)
+	}
+	return cache
+}
+
+func loadHash(url string) string {
+	md5Sum := md5.Sum([]byte(url))
+	return hex.EncodeToString(md5Sum[:])
+}
+
+// loader returns a load function for modules relative to dir.
+// vendor is the path inside loadDir, which is used to package modules of other charts
+func (c *chartImpl) loader(dir string, vendor string, predeclared starlark.StringDict) func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	return func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		if !strings.HasPrefix(module, "@") {
+			name := strings.TrimPrefix(module, "//")
+			file, err := moduleFile(dir, name)
+			if err != nil {
+				return nil, err
+			}
+			if vendor != "" {
+				c.loadedModules[file] = path.Join(vendor, name)
+			}
+			return c.loadModule(thread, file, dir, vendor, predeclared)
+		}
+		// The url of the chart may contain // itself (e.g. https://...)
+		index := strings.LastIndex(module, "//")
+		if index < 0 || strings.HasSuffix(module[1:index], ":") {
+			return nil, fmt.Errorf("Invalid module %s: expected @<chart>//<file>", module)
+		}
+		url, name := module[1:index], module[index+2:]
+		hash := loadHash(url)
+		vendored := c.path(loadDir, hash)
+		file, err := moduleFile(vendored, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(file); err == nil {
+			return c.loadModule(thread, file, vendored, "", predeclared)
+		}
+		if !(filepath.IsAbs(url) || strings.HasPrefix(url, "http")) {
+			url = path.Join(dir, url)
+		}
+		other, err := c.repo.Module(url)
+		if err != nil {
+			return nil, err
+		}
+		file, err = moduleFile(other, name)
+		if err != nil {
+			return nil, err
+		}
+		c.loadedModules[file] = path.Join(hash, name)
+		return c.loadModule(thread, file, other, hash, predeclared)
+	}
+}
+
+// moduleFile returns the file of a module inside dir. Modules outside of dir are rejected
+func moduleFile(dir string, name string) (string, error) {
+	file := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("module %s is outside of %s", name, dir)
+	}
+	return file, nil
+}
+
+func (c *chartImpl) loadModule(thread *starlark.Thread, file string, dir string, vendor string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	cache := loadCache(thread)
+	key := loadKey{chart: c, file: file}
+	entry, ok := cache[key]
+	if ok {
+		if entry == nil {
+			return nil, fmt.Errorf("cycle in load graph: %s", file)
+		}
+		return entry.globals, entry.err
+	}
+	cache[key] = nil
+	globals, err := c.execFile(thread, file, dir, vendor, predeclared)
+	cache[key] = &loadEntry{globals: globals, err: err}
+	return globals, err
+}
+
+// execFile executes a starlark file. All load statements inside this file are resolved relative to dir
+func (c *chartImpl) execFile(thread *starlark.Thread, file string, dir string, vendor string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	load := thread.Load
+	thread.Load = c.loader(dir, vendor, predeclared)
+	defer func() { thread.Load = load }()
+	return starlark.ExecFile(thread, file, nil, predeclared)
+}
@@ -0,0 +1,119 @@
+package shalm
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("load", func() {
+	var dir TestDir
+	thread := &starlark.Thread{Name: "main"}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("chart/lib", 0755)
+		dir.MkdirAll("other/lib", 0755)
+		dir.WriteFile("chart/Chart.yaml", []byte("name: chart\nversion: 1.0.0\n"), 0644)
+		dir.WriteFile("other/Chart.yaml", []byte("name: other\nversion: 1.0.0\n"), 0644)
+		dir.WriteFile("chart/lib/helpers.star", []byte("def greet(name):\n  return \"hello \" + name\n"), 0644)
+		dir.WriteFile("other/lib/util.star", []byte("load(\"//lib/base.star\", \"base\")\ndef upper(name):\n  return base + name.upper()\n"), 0644)
+		dir.WriteFile("other/lib/base.star", []byte("base = \"base-\"\n"), 0644)
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("loads modules relative to the chart directory", func() {
+		dir.WriteFile("chart/Chart.star", []byte("load(\"//lib/helpers.star\", \"greet\")\ndef init(self):\n  self.msg = greet(\"world\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["msg"]).To(Equal(starlark.String("hello world")))
+	})
+
+	It("loads modules of other charts", func() {
+		dir.WriteFile("chart/Chart.star", []byte("load(\"@../other//lib/util.star\", \"upper\")\ndef init(self):\n  self.msg = upper(\"world\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["msg"]).To(Equal(starlark.String("base-WORLD")))
+
+		By("packaging loaded modules")
+		buffer := &bytes.Buffer{}
+		Expect(c.Package(buffer)).NotTo(HaveOccurred())
+		dir.Remove()
+		dir = NewTestDir()
+		c, err = newChartFromReader(thread, NewRepo(), dir.Join("extracted"), buffer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["msg"]).To(Equal(starlark.String("base-WORLD")))
+	})
+
+	It("caches modules", func() {
+		dir.WriteFile("chart/lib/counter.star", []byte("print(\"loaded\")\nvalue = 1\n"), 0644)
+		dir.WriteFile("chart/lib/a.star", []byte("load(\"//lib/counter.star\", \"value\")\na = value\n"), 0644)
+		dir.WriteFile("chart/Chart.star", []byte("load(\"//lib/counter.star\", \"value\")\nload(\"//lib/a.star\", \"a\")\ndef init(self):\n  self.sum = value + a\n"), 0644)
+		count := 0
+		thread := &starlark.Thread{Name: "main", Print: func(thread *starlark.Thread, msg string) { count++ }}
+		c, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["sum"]).To(Equal(starlark.MakeInt(2)))
+		Expect(count).To(Equal(1))
+	})
+
+	It("reports cycles", func() {
+		dir.WriteFile("chart/lib/a.star", []byte("load(\"//lib/b.star\", \"b\")\na = 1\n"), 0644)
+		dir.WriteFile("chart/lib/b.star", []byte("load(\"//lib/a.star\", \"a\")\nb = 1\n"), 0644)
+		dir.WriteFile("chart/Chart.star", []byte("load(\"//lib/a.star\", \"a\")\n"), 0644)
+		_, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("cycle in load graph"))
+	})
+
+	It("loads modules of remote charts", func() {
+		other, err := newChart(thread, NewRepo(), dir.Join("other"))
+		Expect(err).NotTo(HaveOccurred())
+		buffer := &bytes.Buffer{}
+		Expect(other.Package(buffer)).NotTo(HaveOccurred())
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(buffer.Bytes())
+		}))
+		defer server.Close()
+		dir.WriteFile("chart/Chart.star", []byte("load(\"@"+server.URL+"/other.tgz//lib/util.star\", \"upper\")\ndef init(self):\n  self.msg = upper(\"world\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.values["msg"]).To(Equal(starlark.String("base-WORLD")))
+	})
+
+	It("fetches remote modules once without removing the loading chart", func() {
+		other, err := newChart(thread, NewRepo(), dir.Join("other"))
+		Expect(err).NotTo(HaveOccurred())
+		requests := 0
+		buffer := &bytes.Buffer{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Write(buffer.Bytes())
+		}))
+		defer server.Close()
+		url := server.URL + "/other.tgz"
+		dir.WriteFile("other/Chart.star", []byte("load(\"@"+url+"//lib/util.star\", \"upper\")\nload(\"@"+url+"//lib/base.star\", \"base\")\ndef init(self):\n  self.msg = upper(\"world\")\n"), 0644)
+		Expect(other.Package(buffer)).NotTo(HaveOccurred())
+		c, err := NewRepo().Get(thread, url)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.(*chartImpl).values["msg"]).To(Equal(starlark.String("base-WORLD")))
+		Expect(requests).To(Equal(2))
+		Expect(c.(*chartImpl).path("Chart.star")).To(BeAnExistingFile())
+	})
+
+	It("rejects modules outside of the chart", func() {
+		dir.WriteFile("chart/Chart.star", []byte("load(\"//../other/lib/base.star\", \"base\")\n"), 0644)
+		_, err := newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).To(MatchError(ContainSubstring("is outside of")))
+		dir.WriteFile("chart/Chart.star", []byte("load(\"@../other//../chart/lib/helpers.star\", \"greet\")\n"), 0644)
+		_, err = newChart(thread, NewRepo(), dir.Join("chart"))
+		Expect(err).To(MatchError(ContainSubstring("is outside of")))
+	})
+})
@@ -0,0 +1,99 @@
+package shalm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"go.starlark.net/starlark"
+)
+
+// templater renders the objects of a chart
+type templater func(thread *starlark.Thread, writer io.Writer, options *renderer.Options) error
+
+// manifestArgs are the kwargs url, file and objects of __apply and __delete
+type manifestArgs struct {
+	url     string
+	file    string
+	objects starlark.Value
+}
+
+func unpackManifestArgs(parser *kwargsParser) *manifestArgs {
+	result := &manifestArgs{}
+	parser.Arg("url", func(value starlark.Value) {
+		result.url = value.(starlark.String).GoString()
+	})
+	parser.Arg("file", func(value starlark.Value) {
+		result.file = value.(starlark.String).GoString()
+	})
+	parser.Arg("objects", func(value starlark.Value) {
+		result.objects = value
+	})
+	return result
+}
+
+// templater returns a templater, which renders the manifest given by url, file or objects.
+// Without url, file or objects, the templates of the chart are rendered. glob only applies to the templates of the chart
+func (c *chartImpl) templater(args *manifestArgs, rendererOptions *renderer.Options) (templater, error) {
+	if rendererOptions.Glob != "" && (args.objects != nil || args.url != "" || args.file != "") {
+		return nil, fmt.Errorf("glob can't be used together with url, file or objects")
+	}
+	var file string
+	switch {
+	case args.objects != nil:
+		objects, err := toObjects(args.objects)
+		if err != nil {
+			return nil, err
+		}
+		return func(thread *starlark.Thread, writer io.Writer, options *renderer.Options) error {
+			return renderer.ObjectRender(c.namespace, writer, options, objects)
+		}, nil
+	case args.url != "":
+		url := args.url
+		if !(filepath.IsAbs(url) || strings.HasPrefix(url, "http")) {
+			url = c.path(url)
+		}
+		var err error
+		if file, err = c.repo.File(url); err != nil {
+			return nil, err
+		}
+	case args.file != "":
+		file = args.file
+		if !filepath.IsAbs(file) {
+			file = c.path(file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+	default:
+		return c.template, nil
+	}
+	return func(thread *starlark.Thread, writer io.Writer, options *renderer.Options) error {
+		return renderer.DirRender(c.namespace, writer, options, renderer.DirSpec{
+			Dir:          file,
+			FileRenderer: renderer.ManifestFileRenderer,
+			Glob:         "*",
+		})
+	}, nil
+}
+
+// toObjects converts a list of dicts or structs into kubernetes objects
+func toObjects(value starlark.Value) ([]interface{}, error) {
+	converted, err := toGoChecked(value)
+	if err != nil {
+		return nil, err
+	}
+	objects, ok := converted.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("objects must be a list, got %s", value.Type())
+	}
+	for i, obj := range objects {
+		if _, ok := obj.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("object %d must be a dict or struct, got %T", i, obj)
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,155 @@
+package shalm
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Chart manifests", func() {
+
+	var thread *starlark.Thread
+	var dir TestDir
+	var k *FakeK8s
+
+	applied := func(i int) string {
+		writer := bytes.Buffer{}
+		output, _ := k.ApplyArgsForCall(i)
+		output(&writer)
+		return writer.String()
+	}
+
+	BeforeEach(func() {
+		thread = &starlark.Thread{Name: "main"}
+		dir = NewTestDir()
+		k = &FakeK8s{}
+		k.ForNamespaceStub = func(s string) K8s {
+			return k
+		}
+	})
+
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("applies the manifests directory verbatim", func() {
+		dir.MkdirAll("manifests", 0755)
+		dir.WriteFile("manifests/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\ndata:\n  template: '{{ .Values.x }}'\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: cm\nkind: ConfigMap\napiVersion: v1\ndata:\n  template: '{{ .Values.x }}'\n"))
+	})
+
+	It("applies files and urls", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: remote\n---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: ns\n"))
+		}))
+		defer server.Close()
+		dir.WriteFile("local.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: local\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def apply(self, k8s):
+  self.__apply(k8s, file="local.yaml")
+  self.__apply(k8s, url="`+server.URL+`/release.yaml")
+def delete(self, k8s):
+  self.__delete(k8s, url="`+server.URL+`/release.yaml")
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(applied(0)).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: local\nkind: ConfigMap\napiVersion: v1\n"))
+		Expect(applied(1)).To(Equal("---\nmetadata:\n  name: ns\nkind: Namespace\napiVersion: v1\n---\nmetadata:\n  namespace: namespace\n  name: remote\nkind: Service\napiVersion: v1\n"))
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		Expect(k.DeleteCallCount()).To(BeNumerically(">", 0))
+		writer := bytes.Buffer{}
+		output, _ := k.DeleteArgsForCall(0)
+		output(&writer)
+		Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: remote\nkind: Service\napiVersion: v1\n---\nmetadata:\n  name: ns\nkind: Namespace\napiVersion: v1\n"))
+	})
+
+	It("resolves relative urls against the chart directory", func() {
+		dir.WriteFile("local.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: local\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def apply(self, k8s):\n  self.__apply(k8s, url=\"local.yaml\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(applied(0)).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: local\nkind: ConfigMap\napiVersion: v1\n"))
+	})
+
+	It("rejects glob together with url or file", func() {
+		dir.WriteFile("local.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: local\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def apply(self, k8s):\n  self.__apply(k8s, file=\"local.yaml\", glob=\"*.yml\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).To(MatchError(ContainSubstring("glob can't be used together with url, file or objects")))
+	})
+
+	It("fails for missing files", func() {
+		dir.WriteFile("Chart.star", []byte("def apply(self, k8s):\n  self.__apply(k8s, file=\"missing.yaml\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).To(MatchError(ContainSubstring("missing.yaml")))
+	})
+
+	It("applies and deletes objects", func() {
+		dir.WriteFile("Chart.star", []byte(`
+def objects(self):
+  return [
+    {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app"}},
+    struct(apiVersion="v1", kind="ConfigMap", metadata=struct(name="cm")),
+  ]
+def apply(self, k8s):
+  self.__apply(k8s, objects=self.objects())
+def delete(self, k8s):
+  self.__delete(k8s, objects=self.objects())
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(applied(0)).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: cm\nkind: ConfigMap\napiVersion: v1\n---\nmetadata:\n  namespace: namespace\n  name: app\nkind: Deployment\napiVersion: apps/v1\n"))
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		writer := bytes.Buffer{}
+		output, _ := k.DeleteArgsForCall(0)
+		output(&writer)
+		Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: app\nkind: Deployment\napiVersion: apps/v1\n---\nmetadata:\n  namespace: namespace\n  name: cm\nkind: ConfigMap\napiVersion: v1\n"))
+	})
+
+	It("fails for invalid objects", func() {
+		dir.WriteFile("Chart.star", []byte("def apply(self, k8s):\n  self.__apply(k8s, objects=\"cm\")\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).To(MatchError(ContainSubstring("objects must be a list")))
+	})
+
+	It("renders the star directory", func() {
+		dir.MkdirAll("star", 0755)
+		dir.WriteFile("lib.star", []byte("def labels(self):\n  return {\"app\": self.app}\n"), 0644)
+		dir.WriteFile("star/objects.star", []byte(`
+load("//lib.star", "labels")
+def objects(self):
+  return [{"apiVersion": "v1", "kind": "Service", "metadata": {"name": self.app, "labels": labels(self)}},
+          {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "ns"}}]
+`), 0644)
+		dir.WriteFile("values.yaml", []byte("app: test\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("---\nmetadata:\n  name: ns\nkind: Namespace\napiVersion: v1\n---\nmetadata:\n  namespace: namespace\n  name: test\n  labels:\n    app: test\nkind: Service\napiVersion: v1\n"))
+	})
+
+	It("fails for star files without objects", func() {
+		dir.MkdirAll("star", 0755)
+		dir.WriteFile("star/objects.star", []byte("x = 1\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.Template(thread)
+		Expect(err).To(MatchError(ContainSubstring("must define a function objects(self)")))
+	})
+})
@@ -1,45 +1,241 @@
 package shalm
 
 import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/pflag"
 	"go.starlark.net/starlark"
+	"gopkg.in/yaml.v2"
 )
 
 // AddFlags -
 func (v *ChartOptions) AddFlags(flagsSet *pflag.FlagSet) {
-	flagsSet.StringArrayVar(&v.cmdArgs, "set", nil, "Set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flagsSet.StringArrayVar(&v.cmdArgs, "set", nil, "Set values on the command line (can specify multiple or separate values with commas: key1=val1,key2.nested=val2,key3={a,b})")
+	flagsSet.StringArrayVar(&v.stringArgs, "set-string", nil, "Set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flagsSet.StringArrayVar(&v.fileArgs, "set-file", nil, "Set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flagsSet.StringArrayVarP(&v.valueFiles, "values", "f", nil, "Merge values from a YAML file into the chart values (can specify multiple)")
+	flagsSet.StringArrayVar(&v.valueArgs, "values-set", nil, "Override chart values on the command line. Values of sub charts are addressed by path (can specify multiple or separate values with commas: mariadb.slave.replicas=2)")
 	flagsSet.BoolVarP(&v.proxy, "proxy", "p", false, "Install helm chart using a combination of CR and operator")
 	flagsSet.StringVarP(&v.namespace, "namespace", "n", "default", "Namespace for installation")
 	flagsSet.StringVarP(&v.suffix, "suffix", "s", "", "Suffix which is used to build the chart name")
+	flagsSet.StringVar(&v.profile, "profile", "", "Profile, which loads values-<profile>.yaml and Chart.<profile>.star on top of values.yaml and Chart.star")
 }
 
-// Options -
+// AddApplyFlags adds flags, which are only relevant for apply
+func (v *ChartOptions) AddApplyFlags(flagsSet *pflag.FlagSet) {
+	v.applyFlags = true
+	flagsSet.BoolVar(&v.prune, "prune", true, "Delete objects, which were applied before but are no longer part of the chart")
+	flagsSet.IntVar(&v.historyMax, "history-max", defaultHistoryMax, "Maximum number of revisions stored per release. Use 0 for no limit")
+}
+
+// Options returns an option, which applies v. prune and historyMax are only taken over, if the apply flags are bound
 func (v *ChartOptions) Options() ChartOption {
 	if len(v.kwargs) == 0 {
-		v.kwargs = v.kwArgs()
+		v.kwargs, v.err = v.kwArgs()
+	}
+	if len(v.values) == 0 && v.err == nil {
+		v.values, v.err = v.readValues()
 	}
 	return func(o *ChartOptions) {
+		prune, historyMax := o.prune, o.historyMax
 		*o = *v
+		if !v.applyFlags {
+			o.prune, o.historyMax = prune, historyMax
+		}
 	}
 }
 
-func (v *ChartOptions) kwArgs() []starlark.Tuple {
+// kwArgs converts --set, --set-string and --set-file into keyword arguments for init.
+// Nested keys are passed as dict
+func (v *ChartOptions) kwArgs() ([]starlark.Tuple, error) {
+	values := make(map[string]interface{})
+	var keys []string
+	parse := func(args []string, convert func(value string) (interface{}, error)) error {
+		for _, arg := range args {
+			k, err := parseSetValues(arg, values, convert)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k...)
+		}
+		return nil
+	}
+	if err := parse(v.cmdArgs, typedValue); err != nil {
+		return nil, err
+	}
+	if err := parse(v.stringArgs, stringValue); err != nil {
+		return nil, err
+	}
+	if err := parse(v.fileArgs, fileValue); err != nil {
+		return nil, err
+	}
 	var result []starlark.Tuple
-	for _, arg := range v.cmdArgs {
-		for _, a := range strings.Split(arg, ",") {
-			val := strings.Split(a, "=")
-			if len(val) == 2 {
-				result = append(result, starlark.Tuple{starlark.String(val[0]), starlark.String(val[1])})
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, starlark.Tuple{starlark.String(key), toStarlark(values[key])})
+	}
+	return result, nil
+}
+
+// readValues reads all values files followed by the values given with --values-set
+func (v *ChartOptions) readValues() ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	for _, file := range v.valueFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("invalid values file %s: %s", file, err.Error())
+		}
+		result = append(result, values)
+	}
+	if len(v.valueArgs) != 0 {
+		values := make(map[string]interface{})
+		for _, arg := range v.valueArgs {
+			if _, err := parseSetValues(arg, values, typedValue); err != nil {
+				return nil, err
 			}
 		}
+		result = append(result, values)
+	}
+	return result, nil
+}
+
+// parseSetValues parses helm style values (e.g. a.b=1,c={x,y}) into values. It returns the top level keys in order of appearance.
+// Commas and dots inside keys or values can be escaped using a backslash
+func parseSetValues(arg string, values map[string]interface{}, convert func(value string) (interface{}, error)) ([]string, error) {
+	var keys []string
+	for _, pair := range splitEscaped(arg, ',', true) {
+		if pair == "" {
+			continue
+		}
+		index := strings.Index(pair, "=")
+		if index < 0 {
+			return nil, fmt.Errorf("key %q has no value", pair)
+		}
+		path := splitEscaped(pair[:index], '.', false)
+		for i := range path {
+			path[i] = unescape(path[i])
+			if path[i] == "" {
+				return nil, fmt.Errorf("invalid key %q", pair[:index])
+			}
+		}
+		value, err := parseSetValue(pair[index+1:], convert)
+		if err != nil {
+			return nil, err
+		}
+		setPath(values, path, value)
+		keys = append(keys, path[0])
+	}
+	return keys, nil
+}
+
+func parseSetValue(value string, convert func(value string) (interface{}, error)) (interface{}, error) {
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+		result := []interface{}{}
+		for _, item := range splitEscaped(value[1:len(value)-1], ',', false) {
+			v, err := convert(unescape(item))
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	}
+	return convert(unescape(value))
+}
+
+func setPath(values map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := values[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			values[key] = child
+		}
+		values = child
+	}
+	values[path[len(path)-1]] = value
+}
+
+// splitEscaped splits s at each unescaped sep. Escape sequences are kept. If braces is set, sep is ignored inside {}
+func splitEscaped(s string, sep rune, braces bool) []string {
+	var result []string
+	var current strings.Builder
+	depth := 0
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case braces && r == '{':
+			depth++
+		case braces && r == '}' && depth > 0:
+			depth--
+		case r == sep && depth == 0:
+			result = append(result, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	return append(result, current.String())
+}
+
+func unescape(s string) string {
+	var result strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// typedValue infers booleans, integers and null like helm does
+func typedValue(value string) (interface{}, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, nil
+	}
+	return value, nil
+}
+
+func stringValue(value string) (interface{}, error) {
+	return value, nil
+}
+
+func fileValue(value string) (interface{}, error) {
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return nil, err
 	}
-	return result
+	return string(data), nil
 }
 
 func chartOptions(opts []ChartOption) *ChartOptions {
-	co := ChartOptions{}
+	co := ChartOptions{prune: true, historyMax: defaultHistoryMax}
 	for _, option := range opts {
 		option(&co)
 	}
@@ -1,8 +1,12 @@
 package shalm
 
 import (
+	"path"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
 	"go.starlark.net/starlark"
 )
 
@@ -10,7 +14,8 @@ var _ = Describe("Chart Options", func() {
 
 	It("produces the correct output", func() {
 		args := ChartOptions{cmdArgs: []string{"a=b,c=d"}}
-		kwargs := args.kwArgs()
+		kwargs, err := args.kwArgs()
+		Expect(err).NotTo(HaveOccurred())
 		Expect(kwargs).To(HaveLen(2))
 		Expect(kwargs[0]).To(HaveLen(2))
 		Expect(kwargs[0][0]).To(Equal(starlark.String("a")))
@@ -19,4 +24,112 @@ var _ = Describe("Chart Options", func() {
 		Expect(kwargs[1][0]).To(Equal(starlark.String("c")))
 		Expect(kwargs[1][1]).To(Equal(starlark.String("d")))
 	})
+
+	It("infers types and supports nested keys", func() {
+		args := ChartOptions{cmdArgs: []string{"a.b=1,a.c=true,d={x,y}", `e=x=y\,z`, `f\.g=null`}, stringArgs: []string{"a.s=1"}}
+		kwargs, err := args.kwArgs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kwargsToGo(kwargs)).To(Equal(map[string]interface{}{
+			"a":   map[string]interface{}{"b": int64(1), "c": true, "s": "1"},
+			"d":   []interface{}{"x", "y"},
+			"e":   "x=y,z",
+			"f.g": nil,
+		}))
+		Expect(kwargs[0][0]).To(Equal(starlark.String("a")))
+	})
+
+	It("reads values from files", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.WriteFile("cert.pem", []byte("content"), 0644)
+		dir.WriteFile("values.yaml", []byte("replicas: 2\nimage:\n  tag: v1\n"), 0644)
+		args := ChartOptions{fileArgs: []string{"cert=" + path.Join(dir.Root(), "cert.pem")}, valueFiles: []string{path.Join(dir.Root(), "values.yaml")}}
+		co := chartOptions([]ChartOption{args.Options()})
+		Expect(co.err).NotTo(HaveOccurred())
+		Expect(kwargsToGo(co.kwargs)).To(HaveKeyWithValue("cert", "content"))
+		Expect(co.values).To(HaveLen(1))
+		Expect(co.values[0]).To(HaveKeyWithValue("replicas", 2))
+	})
+
+	It("keeps prune and history-max defaults, if apply flags aren't bound", func() {
+		co := chartOptions([]ChartOption{(&ChartOptions{}).Options()})
+		Expect(co.prune).To(BeTrue())
+		Expect(co.historyMax).To(Equal(defaultHistoryMax))
+
+		args := ChartOptions{}
+		flags := pflag.NewFlagSet("apply", pflag.ContinueOnError)
+		args.AddApplyFlags(flags)
+		Expect(flags.Parse([]string{"--prune=false", "--history-max=0"})).NotTo(HaveOccurred())
+		co = chartOptions([]ChartOption{args.Options()})
+		Expect(co.prune).To(BeFalse())
+		Expect(co.historyMax).To(Equal(0))
+	})
+
+	It("merges values files into chart values before init", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.WriteFile("values.yaml", []byte("replicas: 1\nimage:\n  name: mariadb\n  tag: v1\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.timeout = self.replicas * 10\n"), 0644)
+		dir.WriteFile("override.yaml", []byte("replicas: 2\nimage:\n  tag: v2\n"), 0644)
+		args := ChartOptions{valueFiles: []string{path.Join(dir.Root(), "override.yaml")}, valueArgs: []string{"image.tag=v3"}}
+		c, err := newChart(&starlark.Thread{}, NewRepo(), dir.Root(), args.Options())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stringDictToGo(c.values)).To(Equal(map[string]interface{}{
+			"replicas": int64(2),
+			"timeout":  int64(20),
+			"image":    map[string]interface{}{"name": "mariadb", "tag": "v3"},
+		}))
+	})
+
+	It("merges values into sub charts by path", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("mariadb", 0755)
+		dir.WriteFile("mariadb/values.yaml", []byte("slave:\n  replicas: 1\n  image: mariadb\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.mariadb = chart(\"mariadb\")\n"), 0644)
+		args := ChartOptions{valueArgs: []string{"mariadb.slave.replicas=2"}}
+		c, err := newChart(&starlark.Thread{}, NewRepo(), dir.Root(), args.Options())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toGo(c.values["mariadb"])).To(Equal(map[string]interface{}{
+			"slave": map[string]interface{}{"replicas": int64(2), "image": "mariadb"},
+		}))
+	})
+
+	It("merges values into structs of sub charts", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("sub", 0755)
+		dir.WriteFile("sub/Chart.star", []byte("def init(self):\n  self.s = struct(a=1, b=1)\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.sub = chart(\"sub\")\n"), 0644)
+		args := ChartOptions{valueArgs: []string{"sub.s.a=2"}}
+		c, err := newChart(&starlark.Thread{}, NewRepo(), dir.Root(), args.Options())
+		Expect(err).NotTo(HaveOccurred())
+		s := c.values["sub"].(*chartImpl).values["s"]
+		Expect(s).To(BeAssignableToTypeOf(&starlarkStruct{}))
+		Expect(toGo(s)).To(Equal(map[string]interface{}{"a": int64(2), "b": int64(1)}))
+	})
+
+	It("reports values of the wrong type", func() {
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("mariadb", 0755)
+		dir.WriteFile("values.yaml", []byte("image: mariadb\n"), 0644)
+		dir.WriteFile("mariadb/values.yaml", []byte("slave:\n  replicas: 1\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.mariadb = chart(\"mariadb\")\n"), 0644)
+		args := ChartOptions{valueArgs: []string{"image.tag=v2"}}
+		_, err := newChart(&starlark.Thread{}, NewRepo(), dir.Root(), args.Options())
+		Expect(err).To(MatchError("cannot set image.tag: image is a string"))
+		args = ChartOptions{valueArgs: []string{"mariadb.slave={1,2}"}}
+		_, err = newChart(&starlark.Thread{}, NewRepo(), dir.Root(), args.Options())
+		Expect(err).To(MatchError("cannot set mariadb.slave: mariadb.slave is a dict"))
+	})
+
+	It("reports invalid values", func() {
+		args := ChartOptions{cmdArgs: []string{"a"}}
+		_, err := args.kwArgs()
+		Expect(err).To(MatchError(ContainSubstring(`key "a" has no value`)))
+		args = ChartOptions{valueFiles: []string{"/does/not/exist.yaml"}}
+		_, err = newChart(&starlark.Thread{}, NewRepo(), "/does/not/exist", args.Options())
+		Expect(err).To(HaveOccurred())
+	})
 })
@@ -61,12 +61,18 @@ func (c *chartProxy) applyFunction() starlark.Callable {
 				Name: c.namespace,
 			},
 		}
+		values, err := stringDictToGo(c.chartImpl.values)
+		if err != nil {
+			return nil, err
+		}
 		shalmSpec := shalmv1a1.ChartSpec{
-			Values:    shalmv1a1.ClonableMap(stringDictToGo(c.chartImpl.values)),
+			Values:    shalmv1a1.ClonableMap(values),
 			Args:      shalmv1a1.ClonableArray(c.args),
 			KwArgs:    shalmv1a1.ClonableMap(c.kwargs),
 			Namespace: c.namespace,
 			Suffix:    c.suffix,
+			Atomic:    c.atomic,
+			Profile:   c.profile,
 		}
 		kubeConfig := k.KubeConfigContent()
 		if kubeConfig != nil {
@@ -97,7 +103,7 @@ func (c *chartProxy) applyFunction() starlark.Callable {
 				return err
 			}
 			return encoder.Encode(shalmChart, writer)
-		}, &K8sOptions{})
+		}, &K8sOptions{FieldManager: c.fieldManager()})
 	})
 }
 
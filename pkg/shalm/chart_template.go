@@ -2,7 +2,9 @@ package shalm
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"os"
 	"path"
 
 	"github.com/kramerul/shalm/pkg/shalm/renderer"
@@ -59,39 +61,15 @@ func (c *chartImpl) templateRecursive(thread *starlark.Thread, writer io.Writer,
 }
 
 func (c *chartImpl) template(thread *starlark.Thread, writer io.Writer, options *renderer.Options) error {
-	values := stringDictToGo(c.values)
-	methods := make(map[string]interface{})
-	for k, f := range c.methods {
-		method := f
-		methods[k] = func() (interface{}, error) {
-			value, err := method.CallInternal(thread, nil, nil)
-			return toGo(value), err
-		}
+	helmFileRenderer, err := c.helmFileRenderer(thread, options)
+	if err != nil {
+		return err
 	}
-	helmFileRenderer, err := renderer.HelmFileRenderer(c.path(), struct {
-		Values  interface{}
-		Methods map[string]interface{}
-		Chart   chart
-		Release release
-		Files   renderer.Files
-	}{
-		Values:  values,
-		Methods: methods,
-		Chart: chart{
-			Name:       c.clazz.Name,
-			AppVersion: c.Version.String(),
-			Version:    c.Version.String(),
-		},
-		Release: release{
-			Name:      c.GetName(),
-			Namespace: c.namespace,
-			Service:   c.GetName(),
-			Revision:  1,
-			IsInstall: false,
-			IsUpgrade: true,
-		},
-		Files: renderer.Files{Dir: c.dir},
-	})
+	values, err := stringDictToGo(c.values)
+	if err != nil {
+		return err
+	}
+	ytt, err := renderer.NewYttWorkspace(path.Join(c.dir, "ytt"), c, stdlib(), values)
 	if err != nil {
 		return err
 	}
@@ -103,17 +81,30 @@ func (c *chartImpl) template(thread *starlark.Thread, writer io.Writer, options
 		},
 		renderer.DirSpec{
 			Dir:          path.Join(c.dir, "ytt"),
-			FileRenderer: renderer.YttFileRenderer(c),
+			FileRenderer: ytt.FileRenderer,
+			Glob:         "*",
+			Overlay:      ytt.Overlay,
+		},
+		renderer.DirSpec{
+			Dir:          path.Join(c.dir, "kustomize"),
+			FileRenderer: renderer.KustomizeFileRenderer(path.Join(c.dir, "kustomize"), c.namespace, c.suffix),
+			Glob:         "[Kk]ustomization*",
+		},
+		renderer.DirSpec{
+			Dir:          path.Join(c.dir, "manifests"),
+			FileRenderer: renderer.ManifestFileRenderer,
+		},
+		renderer.DirSpec{
+			Dir:          path.Join(c.dir, "star"),
+			FileRenderer: c.starFileRenderer(thread),
+			Glob:         "*.star",
 		})
 
 	if err != nil {
 		return err
 	}
-	if len(c.userCredentials) == 0 {
-		return nil
-	}
-	writer.Write([]byte("---\n"))
-	for _, credential := range c.userCredentials {
+	for _, credential := range c.credentials {
+		writer.Write([]byte("---\n"))
 		err = serializer.Encode(credential.secret(c.namespace), writer)
 		if err != nil {
 			return err
@@ -122,3 +113,136 @@ func (c *chartImpl) template(thread *starlark.Thread, writer io.Writer, options
 
 	return nil
 }
+
+// helmFileRenderer creates a renderer for helm templates with the same context as helm provides
+func (c *chartImpl) helmFileRenderer(thread *starlark.Thread, options *renderer.Options) (func(filename string, writer io.Writer) error, error) {
+	values, err := stringDictToGo(c.values)
+	if err != nil {
+		return nil, err
+	}
+	// Like in helm, .Values.global always exists
+	if _, ok := values["global"]; !ok {
+		values["global"] = map[string]interface{}{}
+	}
+	methods := make(map[string]interface{})
+	for k, f := range c.methods {
+		method := f
+		methods[k] = func() (interface{}, error) {
+			value, err := method.CallInternal(thread, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			return toGoChecked(value)
+		}
+	}
+	data := map[string]interface{}{
+		"Values":  values,
+		"Methods": methods,
+		"Chart": chart{
+			Name:       c.clazz.Name,
+			AppVersion: c.Version.String(),
+			Version:    c.Version.String(),
+		},
+		"Release": release{
+			Name:      c.GetName(),
+			Namespace: c.namespace,
+			Service:   c.GetName(),
+			Revision:  c.revision,
+			IsInstall: c.revision == 1,
+			IsUpgrade: c.revision > 1,
+		},
+		"Files":   renderer.Files{Dir: c.dir},
+		"Profile": c.profile,
+	}
+	if options.Capabilities != nil {
+		data["Capabilities"] = *options.Capabilities
+	}
+	return renderer.HelmFileRenderer(c.path(), c.clazz.Name, data, options.Lookup)
+}
+
+// starFileRenderer creates a renderer for starlark files, which define a function objects(self) returning a list of objects
+func (c *chartImpl) starFileRenderer(thread *starlark.Thread) func(filename string, writer io.Writer) error {
+	predeclared := stdlib()
+	predeclared["struct"] = starlark.NewBuiltin("struct", makeStruct)
+	return func(filename string, writer io.Writer) error {
+		globals, err := c.execFile(thread, filename, c.dir, "", predeclared)
+		if err != nil {
+			return err
+		}
+		f, ok := globals["objects"].(starlark.Callable)
+		if !ok {
+			return fmt.Errorf("%s must define a function objects(self)", filename)
+		}
+		value, err := starlark.Call(thread, f, starlark.Tuple{c}, nil)
+		if err != nil {
+			return err
+		}
+		objects, err := toObjects(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filename, err.Error())
+		}
+		return renderer.WriteObjects(writer, objects)
+	}
+}
+
+// Notes renders templates/NOTES.txt. Like in helm, the notes of sub charts are not rendered
+func (c *chartImpl) Notes(thread *starlark.Thread) (string, error) {
+	file := c.path("templates", "NOTES.txt")
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	helmFileRenderer, err := c.helmFileRenderer(thread, &renderer.Options{})
+	if err != nil {
+		return "", err
+	}
+	var writer bytes.Buffer
+	if err := helmFileRenderer(file, &writer); err != nil {
+		return "", err
+	}
+	return writer.String(), nil
+}
+
+// Outputs calls outputs(self), if it's defined in Chart.star
+func (c *chartImpl) Outputs(thread *starlark.Thread) (map[string]interface{}, error) {
+	outputs, ok := c.methods["outputs"]
+	if !ok {
+		return nil, nil
+	}
+	value, err := starlark.Call(thread, outputs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if value == starlark.None {
+		return nil, nil
+	}
+	converted, err := toGoChecked(value)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("outputs of chart %s must return a dict, got %s", c.GetName(), value.Type())
+	}
+	return result, nil
+}
+
+// lookup implements the helm function lookup. Objects, which don't exist, are returned as empty dict.
+// Listing objects (empty name) isn't supported and also returns an empty dict
+func lookup(k K8s) renderer.Lookup {
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		if name == "" {
+			return map[string]interface{}{}, nil
+		}
+		obj, err := getObject(k, apiVersion, kind, namespace, name)
+		if err != nil {
+			if k.IsNotExist(err) {
+				return map[string]interface{}{}, nil
+			}
+			return nil, err
+		}
+		return obj, nil
+	}
+}
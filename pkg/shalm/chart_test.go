@@ -55,6 +55,33 @@ var _ = Describe("Chart", func() {
 			Expect(attr.(starlark.String).GoString()).To(Equal("30s"))
 		})
 
+		It("loads profile overlays", func() {
+			thread := &starlark.Thread{Name: "main"}
+			dir := NewTestDir()
+			defer dir.Remove()
+			repo := NewRepo()
+			dir.MkdirAll("templates", 0755)
+			dir.MkdirAll("mariadb", 0755)
+			dir.WriteFile("values.yaml", []byte("replicas: 1\nimage:\n  name: nginx\n  tag: v1\n"), 0644)
+			dir.WriteFile("values-prod.yaml", []byte("replicas: 3\nimage:\n  tag: v2\n"), 0644)
+			dir.WriteFile("Chart.star", []byte("def init(self, size=1):\n  self.size = size\n  self.mariadb = chart('mariadb')\n"), 0644)
+			dir.WriteFile("Chart.prod.star", []byte("def init(self):\n  self.size = self.size * 10\n  self.current = self.profile\n"), 0644)
+			dir.WriteFile("mariadb/values-prod.yaml", []byte("storage: 10Gi\n"), 0644)
+			dir.WriteFile("templates/configmap.yaml", []byte("profile: {{ .Profile }}\n"), 0644)
+			c, err := newChart(thread, repo, dir.Root(), WithProfile("prod"), WithKwArgs([]starlark.Tuple{{starlark.String("size"), starlark.MakeInt(2)}}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stringDictToGo(c.values)).To(Equal(map[string]interface{}{
+				"replicas": int64(3),
+				"image":    map[string]interface{}{"name": "nginx", "tag": "v2"},
+				"size":     int64(20),
+				"current":  "prod",
+				"mariadb":  map[string]interface{}{"storage": "10Gi"},
+			}))
+			t, err := c.Template(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(ContainSubstring("profile: prod"))
+		})
+
 	})
 	Context("Chart.start", func() {
 		var dir TestDir
@@ -149,17 +176,15 @@ def delete(self,k8s):
 		It("applies a chart", func() {
 			Expect(c.GetName()).To(Equal("mariadb"))
 			writer := bytes.Buffer{}
-			k := &FakeK8s{
-				ApplyStub: func(i func(io.Writer) error, options *K8sOptions) error {
-					i(&writer)
-					return nil
-				},
-			}
+			k := &FakeK8s{}
 			k.ForNamespaceStub = func(s string) K8s {
 				return k
 			}
 			err := c.Apply(thread, k)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(k.ApplyCallCount()).To(Equal(3))
+			output, _ := k.ApplyArgsForCall(0)
+			output(&writer)
 			Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\nnamespace: namespace\n"))
 		})
 
@@ -180,6 +205,22 @@ def delete(self,k8s):
 			Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\nnamespace: namespace\n"))
 		})
 
+		It("applies a chart with a field manager", func() {
+			k := &FakeK8s{}
+			k.ForNamespaceStub = func(s string) K8s {
+				return k
+			}
+			attr, err := c.Attr("__apply")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = starlark.Call(thread, attr.(starlark.Callable), starlark.Tuple{NewK8sValue(k)},
+				[]starlark.Tuple{{starlark.String("force_conflicts"), starlark.True}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k.ApplyCallCount()).To(Equal(1))
+			_, options := k.ApplyArgsForCall(0)
+			Expect(options.FieldManager).To(Equal("shalm/mariadb"))
+			Expect(options.ForceConflicts).To(BeTrue())
+		})
+
 		It("packages a chart", func() {
 			writer := &bytes.Buffer{}
 			err := c.Package(writer)
@@ -216,6 +257,176 @@ def delete(self,k8s):
 		})
 
 	})
+	It("propagates global values to sub charts", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		repo := NewRepo()
+		dir.MkdirAll("chart1", 0755)
+		dir.MkdirAll("chart2/templates", 0755)
+		dir.MkdirAll("chart3/templates", 0755)
+		dir.WriteFile("chart1/values.yaml", []byte("global:\n  imageRegistry: registry.local\n  storageClass: fast\n"), 0644)
+		dir.WriteFile("chart1/Chart.star", []byte("def init(self):\n  self.globals[\"tier\"] = \"backend\"\n  self.chart2 = chart(\"../chart2\",globals={\"storageClass\": \"slow\"})\n  self.chart3 = chart(\"../chart3\")\n"), 0644)
+		dir.WriteFile("chart2/values.yaml", []byte("global:\n  imageRegistry: docker.io\n  debug: true\n"), 0644)
+		dir.WriteFile("chart2/templates/configmap.yaml", []byte("registry: {{ .Values.global.imageRegistry }}\nstorageClass: {{ .Values.global.storageClass }}\ndebug: {{ .Values.global.debug }}\n"), 0644)
+		dir.WriteFile("chart3/templates/configmap.yaml", []byte("storageClass: {{ .Values.global.storageClass }}\ntier: {{ .Values.global.tier }}\n"), 0644)
+		c, err := newChart(thread, repo, dir.Join("chart1"), WithValues(map[string]interface{}{"global": map[string]interface{}{"imageRegistry": "registry.example.com"}}))
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("debug: true\nregistry: registry.example.com\nstorageClass: slow\n"))
+		Expect(output).To(ContainSubstring("storageClass: fast\ntier: backend\n"))
+		c, err = newChart(thread, repo, dir.Join("chart3"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("renders notes and outputs", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		repo := NewRepo()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("values.yaml", []byte("port: 3306\n"), 0644)
+		dir.WriteFile("templates/NOTES.txt", []byte("Connect to port {{ .Values.port }}"), 0644)
+		dir.WriteFile("Chart.star", []byte("def outputs(self):\n  return {\"port\": self.port}\n"), 0644)
+		c, err := newChart(thread, repo, dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		notes, err := c.Notes(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notes).To(Equal("Connect to port 3306"))
+		outputs, err := c.Outputs(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outputs).To(Equal(map[string]interface{}{"port": int64(3306)}))
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).NotTo(ContainSubstring("Connect"))
+
+		dir.WriteFile("Chart.star", []byte("def outputs(self):\n  return 1\n"), 0644)
+		c, err = newChart(thread, repo, dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.Outputs(thread)
+		Expect(err).To(MatchError(ContainSubstring("must return a dict")))
+	})
+
+	It("renders ytt workspace", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("templates", 0755)
+		dir.MkdirAll("ytt", 0755)
+		dir.WriteFile("values.yaml", []byte("port: 3306\n"), 0644)
+		dir.WriteFile("templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: helm\ndata:\n  port: \"{{ .Values.port }}\"\n"), 0644)
+		dir.WriteFile("ytt/values.yml", []byte("#@data/values\n---\nport: 80\nuser: admin\n"), 0644)
+		dir.WriteFile("ytt/secret.txt", []byte("(@ load(\"@ytt:data\", \"data\") @)apiVersion: v1\nkind: Secret\nmetadata:\n  name: (@= data.values.user @)-(@= str(data.values.port) @)\n"), 0644)
+		dir.WriteFile("ytt/overlay.yml", []byte(`#@ load("@ytt:overlay", "overlay")
+#@ load("@ytt:data", "data")
+#@overlay/match by=overlay.subset({"kind": "ConfigMap"})
+---
+data:
+  #@overlay/match missing_ok=True
+  user: #@ data.values.user
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal(`---
+metadata:
+  namespace: default
+  name: admin-3306
+kind: Secret
+apiVersion: v1
+---
+metadata:
+  namespace: default
+  name: helm
+kind: ConfigMap
+apiVersion: v1
+data:
+  port: "3306"
+  user: admin
+`))
+	})
+
+	It("renders kustomize directory", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("kustomize", 0755)
+		dir.WriteFile("kustomize/kustomization.yaml", []byte("resources:\n- configmap.yaml\ncommonLabels:\n  app: test\n"), 0644)
+		dir.WriteFile("kustomize/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"), WithSuffix("blue"))
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("---\nmetadata:\n  namespace: namespace\n  name: config-blue\n  labels:\n    app: test\nkind: ConfigMap\napiVersion: v1\n"))
+	})
+
+	It("calls lifecycle hooks", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.MkdirAll("templates", 0755)
+		dir.MkdirAll("sub/templates", 0755)
+		dir.WriteFile("templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), 0644)
+		dir.WriteFile("sub/templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: sub\ndata:\n  flag: {{ .Values.flag }}\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def init(self):
+  self.calls = []
+  self.sub = chart("sub")
+def pre_apply(self, k8s):
+  self.sub.flag = "set_by_pre_apply"
+  self.calls = self.calls + ["pre_apply"]
+def post_apply(self, k8s):
+  k8s.get("configmap", "test")
+  k8s.get("configmap", "sub")
+  self.calls = self.calls + ["post_apply"]
+def pre_delete(self, k8s):
+  k8s.get("configmap", "sub")
+  self.calls = self.calls + ["pre_delete"]
+def post_delete(self, k8s):
+  self.calls = self.calls + ["post_delete"]
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		k := newFakeK8sNative()
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(toGo(c.values["calls"])).To(Equal([]interface{}{"pre_apply", "post_apply"}))
+		writer := &bytes.Buffer{}
+		Expect(k.Get("configmap", "sub", writer, &K8sOptions{Namespaced: true})).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring("set_by_pre_apply"))
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		Expect(toGo(c.values["calls"])).To(Equal([]interface{}{"pre_apply", "post_apply", "pre_delete", "post_delete"}))
+		Expect(k.Get("configmap", "sub", writer, &K8sOptions{Namespaced: true})).To(HaveOccurred())
+	})
+
+	It("applies objects with k8s.apply using the field manager of the chart", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def apply(self, k8s):
+  k8s.apply({"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test"}})
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		k := &FakeK8s{}
+		k.ForNamespaceStub = func(s string) K8s {
+			return k
+		}
+		attr, err := c.Attr("apply")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = starlark.Call(thread, attr.(starlark.Callable), starlark.Tuple{NewK8sValue(k)}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k.ApplyCallCount()).To(Equal(1))
+		_, options := k.ApplyArgsForCall(0)
+		Expect(options.FieldManager).To(Equal("shalm/mariadb"))
+		Expect(c.rendered).To(ConsistOf(objectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "namespace", Name: "test"}))
+	})
+
 	It("behaves like starlark value", func() {
 		thread := &starlark.Thread{Name: "main"}
 		dir := NewTestDir()
@@ -276,6 +487,50 @@ def delete(self,k8s):
 		Expect(writer.String()).To(ContainSubstring("  password: "))
 	})
 
+	It("looks up objects during apply", func() {
+		thread := &starlark.Thread{Name: "main"}
+		dir := NewTestDir()
+		defer dir.Remove()
+		repo := NewRepo()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("templates/configmap.yaml", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+data:
+  {{- $cm := lookup "v1" "ConfigMap" .Release.Namespace "other" }}
+  key: {{ if $cm }}{{ $cm.data.key | quote }}{{ else }}"missing"{{ end }}
+`), 0644)
+		c, err := newChart(thread, repo, dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		output, err := c.Template(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("key: missing"))
+		writer := bytes.Buffer{}
+		k := &FakeK8s{
+			ApplyStub: func(i func(io.Writer) error, options *K8sOptions) error {
+				i(&writer)
+				return nil
+			},
+			GetStub: func(kind string, name string, writer io.Writer, k8s *K8sOptions) error {
+				if kind == "ConfigMap" && name == "other" {
+					_, err := writer.Write([]byte(`{"data": {"key": "value"}}`))
+					return err
+				}
+				return errors.New("NotFound")
+			},
+			IsNotExistStub: func(err error) bool {
+				return true
+			},
+		}
+		k.ForNamespaceStub = func(s string) K8s {
+			return k
+		}
+		err = c.Apply(thread, k)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring("key: value"))
+	})
+
 	It("merges values ", func() {
 		thread := &starlark.Thread{Name: "main"}
 		dir := NewTestDir()
@@ -284,7 +539,7 @@ def delete(self,k8s):
 		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.timeout=50\n"), 0644)
 		c, err := newChart(thread, repo, dir.Root())
 		Expect(err).NotTo(HaveOccurred())
-		c.mergeValues(map[string]interface{}{"timeout": 60, "string": "test"})
+		Expect(c.mergeValues(map[string]interface{}{"timeout": 60, "string": "test"})).NotTo(HaveOccurred())
 		Expect(c.values["timeout"]).To(Equal(starlark.MakeInt(60)))
 		Expect(c.values["string"]).To(Equal(starlark.String("test")))
 	})
@@ -3,6 +3,8 @@ package shalm
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -61,69 +63,158 @@ func toStarlark(vi interface{}) starlark.Value {
 	}
 }
 
+// toGo converts v into a go value. It panics, if v can't be converted. Values given by users are converted using toGoChecked
 func toGo(v starlark.Value) interface{} {
+	result, err := toGoChecked(v)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// toGoChecked converts v into a go value. It reports values, which can't be converted, e.g. secret generators outside of secret()
+func toGoChecked(v starlark.Value) (interface{}, error) {
 	if v == nil {
-		return nil
+		return nil, nil
 	}
 	switch v := v.(type) {
 	case starlark.NoneType:
-		return nil
+		return nil, nil
 	case starlark.Bool:
-		return bool(v)
+		return bool(v), nil
 	case starlark.Int:
 		i, _ := v.Int64()
-		return i
+		return i, nil
 	case starlark.Float:
-		return float64(v)
+		return float64(v), nil
 	case starlark.String:
-		return v.GoString()
+		return v.GoString(), nil
 	case starlark.Indexable: // Tuple, List
 		a := make([]interface{}, 0)
 		for i := 0; i < starlark.Len(v); i++ {
-			a = append(a, toGo(v.Index(i)))
+			value, err := toGoChecked(v.Index(i))
+			if err != nil {
+				return nil, at(strconv.Itoa(i), err)
+			}
+			a = append(a, value)
 		}
-		return a
+		return a, nil
 	case starlark.IterableMapping:
 		d := make(map[string]interface{})
 
 		for _, t := range v.Items() {
 			key, ok := t.Index(0).(starlark.String)
 			if ok {
-				value := toGo(t.Index(1))
+				value, err := toGoChecked(t.Index(1))
+				if err != nil {
+					return nil, at(key.GoString(), err)
+				}
 				if value != nil {
 					d[key.GoString()] = value
 				}
 			}
 		}
-		return d
+		return d, nil
 
 	case *chartImpl:
 		return stringDictToGo(v.values)
 	case *userCredential:
-		// userCredentials can't be used for templating
-		return nil
+		// only the checksum of userCredentials can be used for templating
+		if !v.loaded {
+			return nil, nil
+		}
+		return map[string]interface{}{"checksum": v.checksum()}, nil
+	case *genericSecret:
+		// like for userCredentials, only the checksum of secrets can be used for templating
+		if v.data == nil {
+			return nil, nil
+		}
+		return map[string]interface{}{"checksum": v.checksum()}, nil
+	case *certificate:
+		d := make(map[string]interface{})
+		for _, key := range []string{"cert", "key", "ca"} {
+			if value, err := v.Attr(key); err == nil {
+				d[key] = toGo(value)
+			}
+		}
+		return d, nil
 	case *starlarkstruct.Struct:
 		d := starlark.StringDict{}
 		v.ToStringDict(d)
 		return stringDictToGo(d)
+	case *starlarkStruct:
+		return toGoChecked(v.Struct)
 	default:
-		panic(fmt.Errorf("cannot convert %s to starlark", v.Type()))
+		return nil, &convertError{kind: v.Type()}
 	}
 }
 
-func stringDictToGo(stringDict starlark.StringDict) map[string]interface{} {
+func stringDictToGo(stringDict starlark.StringDict) (map[string]interface{}, error) {
 	d := make(map[string]interface{})
 
 	for k, v := range stringDict {
-		value := toGo(v)
+		value, err := toGoChecked(v)
+		if err != nil {
+			return nil, at(k, err)
+		}
 		if value != nil {
 			d[k] = value
 		}
 	}
-	return d
+	return d, nil
+}
+
+// convertError reports a value, which can't be converted into a go value
+type convertError struct {
+	path []string
+	kind string
+}
+
+func (e *convertError) Error() string {
+	if len(e.path) == 0 {
+		return fmt.Sprintf("cannot convert %s", e.kind)
+	}
+	return fmt.Sprintf("cannot convert %s: %s can't be used as value", strings.Join(e.path, "."), e.kind)
+}
+
+// mergeError reports a value, which can't be overridden
+type mergeError struct {
+	path     []string
+	override []string
+	kind     string
 }
 
-func mergeStringDict(value starlark.StringDict, override starlark.IterableMapping) starlark.StringDict {
+func (e *mergeError) Error() string {
+	path := strings.Join(e.path, ".")
+	target := path
+	if len(e.override) > 0 {
+		target += "." + strings.Join(e.override, ".")
+	}
+	return fmt.Sprintf("cannot set %s: %s is a %s", target, path, e.kind)
+}
+
+// at prefixes the path of a mergeError or convertError with key
+func at(key string, err error) error {
+	switch e := err.(type) {
+	case *mergeError:
+		return &mergeError{path: append([]string{key}, e.path...), override: e.override, kind: e.kind}
+	case *convertError:
+		return &convertError{path: append([]string{key}, e.path...), kind: e.kind}
+	}
+	return err
+}
+
+func cannotMerge(value starlark.Value, override starlark.Value) error {
+	e := &mergeError{kind: value.Type()}
+	if m, ok := override.(starlark.IterableMapping); ok && len(m.Items()) > 0 {
+		if key, ok := m.Items()[0].Index(0).(starlark.String); ok {
+			e.override = []string{key.GoString()}
+		}
+	}
+	return e
+}
+
+func mergeStringDict(value starlark.StringDict, override starlark.IterableMapping) (starlark.StringDict, error) {
 	d := starlark.StringDict{}
 	for _, t := range override.Items() {
 		d[t.Index(0).(starlark.String).GoString()] = t.Index(1)
@@ -132,7 +223,10 @@ func mergeStringDict(value starlark.StringDict, override starlark.IterableMappin
 	for k, v := range value {
 		o, found := d[k]
 		if found {
-			value := merge(v, o)
+			value, err := merge(v, o)
+			if err != nil {
+				return nil, at(k, err)
+			}
 			if value != nil && value != starlark.None {
 				d[k] = value
 			}
@@ -140,41 +234,52 @@ func mergeStringDict(value starlark.StringDict, override starlark.IterableMappin
 			d[k] = v
 		}
 	}
-	return d
+	return d, nil
 }
 
-func merge(value starlark.Value, override starlark.Value) starlark.Value {
+// merge merges override into value. An error is returned, if the types of value and override don't match
+func merge(value starlark.Value, override starlark.Value) (starlark.Value, error) {
 	if override == nil {
-		return value
+		return value, nil
+	}
+	if value == nil || value == starlark.None {
+		return override, nil
 	}
 	switch override := override.(type) {
 	case starlark.NoneType:
-		return value
+		return value, nil
 	case starlark.Bool:
-		return override
+		return override, nil
 	case starlark.Int:
-		return override
+		return override, nil
 	case starlark.Float:
-		return override
+		return override, nil
 	case starlark.String:
-		return override
+		return override, nil
 	case starlark.Indexable:
+		v, ok := value.(starlark.Indexable)
+		if _, isString := value.(starlark.String); !ok || isString {
+			return nil, cannotMerge(value, override)
+		}
 		var result []starlark.Value
-		v := value.(starlark.Indexable)
 		for i := 0; i < maxInt(override.Len(), v.Len()); i++ {
 			if i >= override.Len() {
 				result = append(result, v.Index(i))
 			} else if i >= v.Len() {
 				result = append(result, override.Index(i))
 			} else {
-				result = append(result, merge(v.Index(i), override.Index(i)))
+				element, err := merge(v.Index(i), override.Index(i))
+				if err != nil {
+					return nil, at(strconv.Itoa(i), err)
+				}
+				result = append(result, element)
 			}
 		}
-		_, ok := override.(starlark.Tuple)
+		_, ok = override.(starlark.Tuple)
 		if ok {
-			return starlark.Tuple(result)
+			return starlark.Tuple(result), nil
 		}
-		return starlark.NewList(result)
+		return starlark.NewList(result), nil
 	case starlark.IterableMapping:
 		switch value := value.(type) {
 		case starlark.IterableMapping:
@@ -187,7 +292,14 @@ func merge(value starlark.Value, override starlark.Value) starlark.Value {
 				key := t.Index(0)
 				o, found, err := d.Get(key)
 				if found && err == nil {
-					value := merge(t.Index(1), o)
+					value, err := merge(t.Index(1), o)
+					if err != nil {
+						name := key.String()
+						if k, ok := key.(starlark.String); ok {
+							name = k.GoString()
+						}
+						return nil, at(name, err)
+					}
 					if value != nil && value != starlark.None {
 						d.SetKey(key, value)
 					}
@@ -195,19 +307,36 @@ func merge(value starlark.Value, override starlark.Value) starlark.Value {
 					d.SetKey(key, t.Index(1))
 				}
 			}
-			return d
+			return d, nil
 		case *chartImpl:
-			value.values = mergeStringDict(value.values, override)
-			return value
+			values, err := mergeStringDict(value.values, override)
+			if err != nil {
+				return nil, err
+			}
+			value.values = values
+			return value, nil
 		case *starlarkstruct.Struct:
 			d := starlark.StringDict{}
 			value.ToStringDict(d)
-			return starlarkstruct.FromStringDict(starlarkstruct.Default, mergeStringDict(d, override))
+			values, err := mergeStringDict(d, override)
+			if err != nil {
+				return nil, err
+			}
+			return starlarkstruct.FromStringDict(starlarkstruct.Default, values), nil
+		case *starlarkStruct:
+			merged, err := merge(value.Struct, override)
+			if err != nil {
+				return nil, err
+			}
+			return &starlarkStruct{merged.(*starlarkstruct.Struct)}, nil
+		case *userCredential, *certificate, *genericSecret:
+			// Credentials are read from kubernetes. Their values (e.g. of an old revision) are ignored
+			return value, nil
 		default:
-			panic(fmt.Errorf("cannot merge %s", value.Type()))
+			return nil, cannotMerge(value, override)
 		}
 	default:
-		panic(fmt.Errorf("cannot merge %s", override.Type()))
+		return nil, cannotMerge(value, override)
 	}
 }
 
@@ -60,12 +60,27 @@ var _ = Describe("Converter", func() {
 		Expect(merge(v, o)).To(Equal(o))
 
 		v.SetKey(starlark.String("k3"), starlark.String("v3"))
-		element, found, err := merge(v, o).(starlark.IterableMapping).Get(starlark.String("k3"))
+		merged, err := merge(v, o)
+		Expect(err).NotTo(HaveOccurred())
+		element, found, err := merged.(starlark.IterableMapping).Get(starlark.String("k3"))
 		Expect(found).To(BeTrue())
 		Expect(err).NotTo(HaveOccurred())
 		Expect(element).To(Equal(starlark.String("v3")))
 	})
 
+	It("reports values, which can't be merged", func() {
+		o := starlark.NewDict(0)
+		o.SetKey(starlark.String("tag"), starlark.String("v2"))
+		v := starlark.NewDict(0)
+		v.SetKey(starlark.String("image"), starlark.String("mariadb"))
+		override := starlark.NewDict(0)
+		override.SetKey(starlark.String("image"), o)
+		_, err := merge(v, override)
+		Expect(err).To(MatchError("cannot set image.tag: image is a string"))
+		_, err = merge(o, starlark.NewList([]starlark.Value{starlark.String("x")}))
+		Expect(err).To(MatchError(ContainSubstring("is a dict")))
+	})
+
 	It("merges string dicts", func() {
 		v := starlark.StringDict{}
 		v["k1"] = starlark.String("v1")
@@ -0,0 +1,383 @@
+package shalm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretGenerator generates the content of one key of a secret. Additional keys are stored as <key><suffix>
+type secretGenerator struct {
+	name string
+	// suffixes of the additional keys, which are generated together with the key (e.g. .pub)
+	suffixes []string
+	generate func() (map[string][]byte, error)
+}
+
+var (
+	_ starlark.Value = (*secretGenerator)(nil)
+)
+
+// String -
+func (g *secretGenerator) String() string { return g.name }
+
+// Type -
+func (g *secretGenerator) Type() string { return "secret_generator" }
+
+// Freeze -
+func (g *secretGenerator) Freeze() {}
+
+// Truth -
+func (g *secretGenerator) Truth() starlark.Bool { return true }
+
+// Hash -
+func (g *secretGenerator) Hash() (uint32, error) { return starlark.String(g.name).Hash() }
+
+func secretGenerators() starlark.StringDict {
+	return starlark.StringDict{
+		"random":      starlark.NewBuiltin("random", makeRandomGenerator),
+		"random_hex":  starlark.NewBuiltin("random_hex", makeRandomHexGenerator),
+		"rsa_key":     starlark.NewBuiltin("rsa_key", makeRsaKeyGenerator),
+		"ed25519_key": starlark.NewBuiltin("ed25519_key", makeEd25519KeyGenerator),
+		"ssh_key":     starlark.NewBuiltin("ssh_key", makeSSHKeyGenerator),
+		"htpasswd":    starlark.NewBuiltin("htpasswd", makeHtpasswdGenerator),
+	}
+}
+
+func makeRandomGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	length := 16
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "length?", &length); err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("%s: length must be positive", fn.Name())
+	}
+	return &secretGenerator{name: fmt.Sprintf("random(%d)", length), generate: func() (map[string][]byte, error) {
+		value, err := createRandomString(length)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{"": []byte(value)}, nil
+	}}, nil
+}
+
+func makeRandomHexGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	length := 32
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "length?", &length); err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("%s: length must be positive", fn.Name())
+	}
+	return &secretGenerator{name: fmt.Sprintf("random_hex(%d)", length), generate: func() (map[string][]byte, error) {
+		data := make([]byte, (length+1)/2)
+		if _, err := rand.Read(data); err != nil {
+			return nil, err
+		}
+		return map[string][]byte{"": []byte(hex.EncodeToString(data)[:length])}, nil
+	}}, nil
+}
+
+func makeRsaKeyGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	bits := 2048
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "bits?", &bits); err != nil {
+		return nil, err
+	}
+	if bits <= 0 {
+		return nil, fmt.Errorf("%s: bits must be positive", fn.Name())
+	}
+	return &secretGenerator{name: fmt.Sprintf("rsa_key(%d)", bits), suffixes: []string{".pub"}, generate: func() (map[string][]byte, error) {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			"":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			".pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}),
+		}, nil
+	}}, nil
+}
+
+func makeEd25519KeyGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return &secretGenerator{name: "ed25519_key()", suffixes: []string{".pub"}, generate: func() (map[string][]byte, error) {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			"":     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}),
+			".pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}),
+		}, nil
+	}}, nil
+}
+
+func makeSSHKeyGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	bits := 4096
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "bits?", &bits); err != nil {
+		return nil, err
+	}
+	if bits <= 0 {
+		return nil, fmt.Errorf("%s: bits must be positive", fn.Name())
+	}
+	return &secretGenerator{name: fmt.Sprintf("ssh_key(%d)", bits), suffixes: []string{".pub"}, generate: func() (map[string][]byte, error) {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		public, err := ssh.NewPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			"":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			".pub": ssh.MarshalAuthorizedKey(public),
+		}, nil
+	}}, nil
+}
+
+func makeHtpasswdGenerator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var username, password string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "username", &username, "password?", &password); err != nil {
+		return nil, err
+	}
+	return &secretGenerator{name: fmt.Sprintf("htpasswd(%s)", username), suffixes: []string{".password"}, generate: func() (map[string][]byte, error) {
+		pw := password
+		if pw == "" {
+			var err error
+			if pw, err = createRandomString(16); err != nil {
+				return nil, err
+			}
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			"":          []byte(username + ":" + string(hash)),
+			".password": []byte(pw),
+		}, nil
+	}}, nil
+}
+
+type genericSecret struct {
+	name       string
+	secretType string
+	keys       map[string]starlark.Value
+	data       map[string][]byte
+}
+
+var (
+	_ credential       = (*genericSecret)(nil)
+	_ starlark.Mapping = (*genericSecret)(nil)
+)
+
+func makeGenericSecret(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+	s := &genericSecret{secretType: string(corev1.SecretTypeOpaque), keys: map[string]starlark.Value{}}
+	keys := &starlark.Dict{}
+	if err := starlark.UnpackArgs("secret", args, kwargs, "name", &s.name, "keys?", &keys, "type?", &s.secretType); err != nil {
+		return starlark.None, err
+	}
+	for _, item := range keys.Items() {
+		key, ok := item.Index(0).(starlark.String)
+		if !ok {
+			return starlark.None, fmt.Errorf("secret: keys must be strings")
+		}
+		switch item.Index(1).(type) {
+		case starlark.String, *secretGenerator:
+		default:
+			return starlark.None, fmt.Errorf("secret: value of key %s must be a string or a generator", key.GoString())
+		}
+		s.keys[key.GoString()] = item.Index(1)
+	}
+	return s, nil
+}
+
+// String -
+func (s *genericSecret) String() string {
+	buf := new(strings.Builder)
+	buf.WriteString("secret")
+	buf.WriteByte('(')
+	buf.WriteString("name = ")
+	buf.WriteString(s.name)
+	buf.WriteString(", type = ")
+	buf.WriteString(s.secretType)
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// GetOrCreate reads the secret from kubernetes and generates all missing keys.
+// Keys, which are neither declared nor generated together with a declared key, are removed
+func (s *genericSecret) GetOrCreate(k8s K8s) error {
+	s.data = map[string][]byte{}
+	existing := map[string][]byte{}
+	var buffer bytes.Buffer
+	err := k8s.Get("secret", s.name, &buffer, &K8sOptions{Namespaced: true})
+	if err != nil {
+		if !k8s.IsNotExist(err) {
+			return err
+		}
+	} else {
+		var secret corev1.Secret
+		if _, _, err = serializer.Decode(buffer.Bytes(), nil, &secret); err != nil {
+			return err
+		}
+		existing = secret.Data
+	}
+	for _, key := range s.sortedKeys() {
+		switch value := s.keys[key].(type) {
+		case starlark.String:
+			s.data[key] = []byte(value.GoString())
+		case *secretGenerator:
+			if v, found := existing[key]; found {
+				s.data[key] = v
+				for _, suffix := range value.suffixes {
+					if v, found := existing[key+suffix]; found {
+						s.data[key+suffix] = v
+					}
+				}
+				continue
+			}
+			generated, err := value.generate()
+			if err != nil {
+				return err
+			}
+			for suffix, v := range generated {
+				s.data[key+suffix] = v
+			}
+		}
+	}
+	return nil
+}
+
+func (s *genericSecret) sortedKeys() []string {
+	var result []string
+	for k := range s.keys {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s *genericSecret) secret(namespace string) *corev1.Secret {
+	data := map[string][]byte{}
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretType(s.secretType),
+		Data: data,
+	}
+}
+
+// Type -
+func (s *genericSecret) Type() string { return "secret" }
+
+// Freeze -
+func (s *genericSecret) Freeze() {}
+
+// Truth -
+func (s *genericSecret) Truth() starlark.Bool { return false }
+
+// Hash -
+func (s *genericSecret) Hash() (uint32, error) { return 0, fmt.Errorf("secret is unhashable") }
+
+// Get returns the value of a key. It is only available after the secret is applied
+func (s *genericSecret) Get(key starlark.Value) (starlark.Value, bool, error) {
+	k, ok := key.(starlark.String)
+	if !ok {
+		return nil, false, fmt.Errorf("secret: key must be a string")
+	}
+	if s.data == nil {
+		return nil, false, fmt.Errorf("%s is only available after secret is applied", k.GoString())
+	}
+	value, found := s.data[k.GoString()]
+	if !found {
+		return nil, false, nil
+	}
+	return starlark.String(value), true, nil
+}
+
+// Attr -
+func (s *genericSecret) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		return starlark.String(s.name), nil
+	default:
+		return starlark.None, starlark.NoSuchAttrError(fmt.Sprintf("secret has no .%s attribute", name))
+	}
+}
+
+// AttrNames -
+func (s *genericSecret) AttrNames() []string {
+	return []string{"name"}
+}
+
+// checksum changes whenever the data of the secret changes. It can be used as annotation
+// inside pod templates to roll deployments
+func (s *genericSecret) checksum() string {
+	var keys []string
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(s.data[k])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func createRandomString(length int) (string, error) {
+	chars := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+	max := big.NewInt(int64(len(chars)))
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(chars[n.Int64()])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,143 @@
+package shalm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+)
+
+const (
+	hookPreInstall  = "pre-install"
+	hookPostInstall = "post-install"
+	hookPreUpgrade  = "pre-upgrade"
+	hookPostUpgrade = "post-upgrade"
+	hookPreDelete   = "pre-delete"
+	hookPostDelete  = "post-delete"
+
+	hookBeforeCreation = "before-hook-creation"
+	hookSucceeded      = "hook-succeeded"
+	hookFailed         = "hook-failed"
+
+	// hookTimeout is used to wait for jobs and pods, if no timeout is given. It's the same default as in helm
+	hookTimeout = 5 * time.Minute
+)
+
+// applyHookEvents returns the pre and post events for the current revision
+func (c *chartImpl) applyHookEvents() (string, string) {
+	if c.revision > 1 {
+		return hookPreUpgrade, hookPostUpgrade
+	}
+	return hookPreInstall, hookPostInstall
+}
+
+// runHooks applies all helm hooks for the given event ordered by helm.sh/hook-weight and waits for jobs and pods to complete
+func (c *chartImpl) runHooks(k K8s, hooks []renderer.Hook, event string, k8sOptions *K8sOptions) error {
+	var selected []renderer.Hook
+	for _, hook := range hooks {
+		if hook.HasEvent(event) {
+			selected = append(selected, hook)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].Weight != selected[j].Weight {
+			return selected[i].Weight < selected[j].Weight
+		}
+		if selected[i].Kind != selected[j].Kind {
+			return selected[i].Kind < selected[j].Kind
+		}
+		return selected[i].Name < selected[j].Name
+	})
+	for _, hook := range selected {
+		if err := c.runHook(k.ForNamespace(hook.Namespace), hook, k8sOptions); err != nil {
+			return fmt.Errorf("%s hook %s %s failed: %s", event, hook.Kind, hook.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (c *chartImpl) runHook(k K8s, hook renderer.Hook, k8sOptions *K8sOptions) error {
+	options := &K8sOptions{Namespaced: hook.Namespace != "", Timeout: k8sOptions.Timeout}
+	// before-hook-creation is the default, if no delete policy is given
+	if len(hook.DeletePolicies) == 0 || hook.HasDeletePolicy(hookBeforeCreation) {
+		if err := k.DeleteObject(kindArg(hook.APIVersion, hook.Kind), hook.Name, options); err != nil {
+			return err
+		}
+	}
+	err := k.Apply(func(writer io.Writer) error {
+		if _, err := writer.Write([]byte("---\n")); err != nil {
+			return err
+		}
+		_, err := writer.Write(hook.Content)
+		return err
+	}, &K8sOptions{Timeout: k8sOptions.Timeout, FieldManager: k8sOptions.FieldManager})
+	if err == nil {
+		err = waitForHook(k, hook, options)
+	}
+	if err != nil {
+		if hook.HasDeletePolicy(hookFailed) {
+			k.DeleteObject(kindArg(hook.APIVersion, hook.Kind), hook.Name, options)
+		}
+		return err
+	}
+	if hook.HasDeletePolicy(hookSucceeded) {
+		return k.DeleteObject(kindArg(hook.APIVersion, hook.Kind), hook.Name, options)
+	}
+	return nil
+}
+
+// waitForHook waits until a job or pod has completed. It fails as soon as the job or pod has failed
+func waitForHook(k K8s, hook renderer.Hook, options *K8sOptions) error {
+	if hook.Kind != "Job" && hook.Kind != "Pod" {
+		return nil
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = hookTimeout
+	}
+	start := time.Now()
+	for {
+		obj, err := getObject(k, hook.APIVersion, hook.Kind, hook.Namespace, hook.Name)
+		if err != nil {
+			return err
+		}
+		done, err := hookCompleted(hook.Kind, obj)
+		if err != nil || done {
+			return err
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("timeout after %s", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// hookCompleted checks the status of a job or pod
+func hookCompleted(kind string, obj map[string]interface{}) (bool, error) {
+	status, _ := obj["status"].(map[string]interface{})
+	if kind == "Pod" {
+		switch status["phase"] {
+		case "Succeeded":
+			return true, nil
+		case "Failed":
+			return false, fmt.Errorf("pod failed: %v", status["message"])
+		}
+		return false, nil
+	}
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["status"] != "True" {
+			continue
+		}
+		switch condition["type"] {
+		case "Complete":
+			return true, nil
+		case "Failed":
+			return false, fmt.Errorf("job failed: %v", condition["reason"])
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,153 @@
+package shalm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Helm hooks", func() {
+	var dir TestDir
+	var calls []string
+	var k *FakeK8s
+	var statuses map[string]string
+	thread := &starlark.Thread{Name: "main"}
+	names := regexp.MustCompile(`(?m)^  name: (\S+)$`)
+
+	hook := func(kind string, name string, event string, weight int, policy string) string {
+		return fmt.Sprintf(`apiVersion: v1
+kind: %s
+metadata:
+  name: %s
+  annotations:
+    helm.sh/hook: %s
+    helm.sh/hook-weight: "%d"
+    helm.sh/hook-delete-policy: %s
+`, kind, name, event, weight, policy)
+	}
+
+	BeforeEach(func() {
+		calls = nil
+		statuses = map[string]string{}
+		pollInterval = 0
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("Chart.yaml", []byte("name: hooks\nversion: 1.0.0\n"), 0644)
+		dir.WriteFile("templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"), 0644)
+		dir.WriteFile("templates/migrate.yaml", []byte(hook("Job", "migrate", "pre-install,pre-upgrade", 5, "hook-succeeded,hook-failed")), 0644)
+		dir.WriteFile("templates/prepare.yaml", []byte(hook("Pod", "prepare", "pre-install", -5, "before-hook-creation")), 0644)
+		dir.WriteFile("templates/smoke.yaml", []byte(hook("Job", "smoke", "post-install", 0, "")), 0644)
+		dir.WriteFile("templates/cleanup.yaml", []byte(hook("Job", "cleanup", "pre-delete", 0, "hook-succeeded")), 0644)
+		dir.WriteFile("templates/test.yaml", []byte(hook("Pod", "test", "test", 0, "")), 0644)
+		k = &FakeK8s{
+			ApplyStub: func(output func(io.Writer) error, options *K8sOptions) error {
+				buffer := &bytes.Buffer{}
+				output(buffer)
+				var applied []string
+				for _, match := range names.FindAllStringSubmatch(buffer.String(), -1) {
+					applied = append(applied, match[1])
+				}
+				calls = append(calls, "apply "+strings.Join(applied, ","))
+				return nil
+			},
+			DeleteStub: func(output func(io.Writer) error, options *K8sOptions) error {
+				buffer := &bytes.Buffer{}
+				output(buffer)
+				Expect(buffer.String()).NotTo(ContainSubstring("cleanup"))
+				calls = append(calls, "delete")
+				return nil
+			},
+			DeleteObjectStub: func(kind string, name string, options *K8sOptions) error {
+				calls = append(calls, "delete "+kind+" "+name)
+				return nil
+			},
+			GetStub: func(kind string, name string, writer io.Writer, options *K8sOptions) error {
+				switch kind {
+				case "Job":
+					calls = append(calls, "wait "+kind+" "+name)
+					status, ok := statuses[name]
+					if !ok {
+						status = `{"conditions": [{"type": "Complete", "status": "True"}]}`
+					}
+					_, err := writer.Write([]byte(`{"status": ` + status + `}`))
+					return err
+				case "Pod":
+					calls = append(calls, "wait "+kind+" "+name)
+					_, err := writer.Write([]byte(`{"status": {"phase": "Succeeded"}}`))
+					return err
+				}
+				return errors.New("NotFound")
+			},
+			IsNotExistStub: func(err error) bool {
+				return true
+			},
+		}
+		k.ForNamespaceStub = func(s string) K8s {
+			return k
+		}
+	})
+	AfterEach(func() {
+		pollInterval = defaultPollInterval
+		dir.Remove()
+	})
+
+	It("runs install hooks ordered by weight", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(calls).To(ContainElement("apply config"))
+		Expect(calls[:10]).To(Equal([]string{
+			"delete Pod prepare",
+			"apply prepare",
+			"wait Pod prepare",
+			"apply migrate",
+			"wait Job migrate",
+			"delete Job migrate",
+			"apply config",
+			"delete Job smoke",
+			"apply smoke",
+			"wait Job smoke",
+		}))
+		Expect(strings.Join(calls, ";")).NotTo(ContainSubstring("test"))
+	})
+
+	It("runs delete hooks", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		Expect(calls[:4]).To(Equal([]string{
+			"apply cleanup",
+			"wait Job cleanup",
+			"delete Job cleanup",
+			"delete",
+		}))
+	})
+
+	It("deletes failed hooks and stops", func() {
+		statuses["migrate"] = `{"conditions": [{"type": "Failed", "status": "True", "reason": "BackoffLimitExceeded"}]}`
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		err = c.Apply(thread, k)
+		Expect(err).To(MatchError(ContainSubstring("pre-install hook Job migrate failed: job failed: BackoffLimitExceeded")))
+		Expect(calls).To(ContainElement("delete Job migrate"))
+		Expect(calls).NotTo(ContainElement("apply config"))
+	})
+
+	It("times out waiting for hooks", func() {
+		statuses["migrate"] = `{}`
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		err = c.applyLocal(thread, NewK8sValue(k), &K8sOptions{Timeout: time.Millisecond}, &renderer.Options{}, c.template)
+		Expect(err).To(MatchError(ContainSubstring("pre-install hook Job migrate failed: timeout after 1ms")))
+	})
+})
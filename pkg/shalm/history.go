@@ -0,0 +1,251 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	shalmv1a1 "github.com/kramerul/shalm/api/v1alpha1"
+	"go.starlark.net/starlark"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	revisionKey        = "chart"
+	revisionSecretType = "shalm.kramerul.github.com/revision"
+	// defaultHistoryMax is the default number of revisions stored per release (same as in helm)
+	defaultHistoryMax = 10
+)
+
+// revisionInfo describes one revision of a release
+type revisionInfo struct {
+	Revision    int       `json:"revision"`
+	Chart       string    `json:"chart"`
+	Version     string    `json:"version"`
+	Updated     time.Time `json:"updated"`
+	Description string    `json:"description"`
+}
+
+func revisionSecretName(name string, revision int) string {
+	return fmt.Sprintf("%s.v%d", inventorySecretName(name), revision)
+}
+
+// lastRevision returns the last revision of the release or 0 if the release was never applied
+func (i *inventory) lastRevision() int {
+	if len(i.History) == 0 {
+		return 0
+	}
+	return i.History[len(i.History)-1].Revision
+}
+
+func (i *inventory) findRevision(revision int) *revisionInfo {
+	for index := range i.History {
+		if i.History[index].Revision == revision {
+			return &i.History[index]
+		}
+	}
+	return nil
+}
+
+// pruneHistory removes the oldest revisions, if there are more than historyMax. It returns the removed revisions.
+// A historyMax of 0 keeps all revisions
+func (i *inventory) pruneHistory(historyMax int) []revisionInfo {
+	if historyMax <= 0 || len(i.History) <= historyMax {
+		return nil
+	}
+	pruned := i.History[:len(i.History)-historyMax]
+	i.History = i.History[len(i.History)-historyMax:]
+	return pruned
+}
+
+// deleteRevisions deletes the secrets of the given revisions
+func (c *chartImpl) deleteRevisions(k K8s, revisions []revisionInfo) error {
+	for _, r := range revisions {
+		err := k.DeleteObject("secret", revisionSecretName(c.GetName(), r.Revision), &K8sOptions{Namespaced: true})
+		if err != nil && !k.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *chartImpl) setRevision(revision int) {
+	c.eachSubChart(func(subChart *chartImpl) error {
+		subChart.setRevision(revision)
+		return nil
+	})
+	c.revision = revision
+}
+
+// chartSpec returns all information, which is required to apply this chart again
+func (c *chartImpl) chartSpec() (*shalmv1a1.ChartSpec, error) {
+	buffer := &bytes.Buffer{}
+	if err := c.Package(buffer); err != nil {
+		return nil, err
+	}
+	values, err := specValues(c.values)
+	if err != nil {
+		return nil, err
+	}
+	return &shalmv1a1.ChartSpec{
+		Values:    shalmv1a1.ClonableMap(values),
+		Args:      shalmv1a1.ClonableArray(toGo(c.args).([]interface{})),
+		KwArgs:    shalmv1a1.ClonableMap(kwargsToGo(c.kwargs)),
+		Namespace: c.namespace,
+		Suffix:    c.suffix,
+		Profile:   c.profile,
+		ChartTgz:  buffer.Bytes(),
+	}, nil
+}
+
+// specValues converts values like stringDictToGo, but leaves out credentials. They are read from kubernetes again
+// and must not be stored (e.g. private keys of certificates)
+func specValues(values starlark.StringDict) (map[string]interface{}, error) {
+	d := make(map[string]interface{})
+	for k, v := range values {
+		value, err := specValue(v)
+		if err != nil {
+			return nil, at(k, err)
+		}
+		if value != nil {
+			d[k] = value
+		}
+	}
+	return d, nil
+}
+
+func specValue(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case *userCredential, *certificate, *genericSecret:
+		return nil, nil
+	case *chartImpl:
+		return specValues(v.values)
+	case starlark.String:
+		return v.GoString(), nil
+	case starlark.Indexable:
+		a := make([]interface{}, 0)
+		for i := 0; i < v.Len(); i++ {
+			value, err := specValue(v.Index(i))
+			if err != nil {
+				return nil, at(strconv.Itoa(i), err)
+			}
+			a = append(a, value)
+		}
+		return a, nil
+	case starlark.IterableMapping:
+		d := make(map[string]interface{})
+		for _, t := range v.Items() {
+			key, ok := t.Index(0).(starlark.String)
+			if !ok {
+				continue
+			}
+			value, err := specValue(t.Index(1))
+			if err != nil {
+				return nil, at(key.GoString(), err)
+			}
+			if value != nil {
+				d[key.GoString()] = value
+			}
+		}
+		return d, nil
+	}
+	return toGoChecked(v)
+}
+
+func (c *chartImpl) writeRevision(k K8s, revision int) error {
+	spec, err := c.chartSpec()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionSecretName(c.GetName(), revision),
+			Namespace: c.namespace,
+		},
+		Type: revisionSecretType,
+		Data: map[string][]byte{revisionKey: data},
+	}
+	return k.Apply(func(writer io.Writer) error {
+		return serializer.Encode(secret, writer)
+	}, &K8sOptions{FieldManager: "shalm"})
+}
+
+func readRevision(k K8s, name string, revision int) (*shalmv1a1.ChartSpec, error) {
+	var buffer bytes.Buffer
+	if err := k.Get("secret", revisionSecretName(name, revision), &buffer, &K8sOptions{Namespaced: true}); err != nil {
+		return nil, err
+	}
+	var secret corev1.Secret
+	if _, _, err := serializer.Decode(buffer.Bytes(), nil, &secret); err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[revisionKey]
+	if !ok {
+		return nil, fmt.Errorf("revision %d of %s doesn't contain a chart", revision, name)
+	}
+	spec := &shalmv1a1.ChartSpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// History writes all revisions of a release to writer
+func History(k K8s, namespace string, name string, writer io.Writer) error {
+	inv, err := readInventory(k.ForNamespace(namespace), name)
+	if err != nil {
+		return err
+	}
+	if len(inv.History) == 0 {
+		return fmt.Errorf("release %s not found in namespace %s", name, namespace)
+	}
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tUPDATED\tCHART\tVERSION\tDESCRIPTION")
+	for _, r := range inv.History {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.Revision, r.Updated.Format(time.RFC3339), r.Chart, r.Version, r.Description)
+	}
+	return w.Flush()
+}
+
+// Rollback applies an old revision of a release again. If revision is 0, the previous revision is used
+func Rollback(thread *starlark.Thread, repo Repo, k K8s, namespace string, name string, revision int) error {
+	inv, err := readInventory(k.ForNamespace(namespace), name)
+	if err != nil {
+		return err
+	}
+	if revision == 0 {
+		if len(inv.History) < 2 {
+			return fmt.Errorf("release %s has no previous revision", name)
+		}
+		revision = inv.History[len(inv.History)-2].Revision
+	}
+	if inv.findRevision(revision) == nil {
+		return fmt.Errorf("revision %d of release %s not found", revision, name)
+	}
+	spec, err := readRevision(k.ForNamespace(namespace), name, revision)
+	if err != nil {
+		return err
+	}
+	c, err := repo.GetFromSpec(thread, spec)
+	if err != nil {
+		return err
+	}
+	chart, ok := c.(*chartImpl)
+	if !ok {
+		return c.Apply(thread, k)
+	}
+	return chart.applyRelease(thread, k, "rollback to "+strconv.Itoa(revision))
+}
@@ -0,0 +1,136 @@
+package shalm
+
+import (
+	"bytes"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("History", func() {
+	var dir TestDir
+	var k *k8sNativeImpl
+	thread := &starlark.Thread{Name: "main"}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("templates/configmap.yaml", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+data:
+  revision: "{{ .Release.Revision }}"
+  install: "{{ .Release.IsInstall }}"
+  key: {{ .Values.key }}
+`), 0644)
+		k = newFakeK8sNative()
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	applyWith := func(key string) {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		c.SetField("key", starlark.String(key))
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+	}
+
+	configMap := func() string {
+		writer := &bytes.Buffer{}
+		err := k.Get("configmap", "test", writer, &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		return writer.String()
+	}
+
+	It("stores revisions", func() {
+		applyWith("v1")
+		Expect(configMap()).To(ContainSubstring(`"revision":"1"`))
+		Expect(configMap()).To(ContainSubstring(`"install":"true"`))
+		applyWith("v2")
+		Expect(configMap()).To(ContainSubstring(`"revision":"2"`))
+		Expect(configMap()).To(ContainSubstring(`"install":"false"`))
+
+		writer := &bytes.Buffer{}
+		err := History(k, "namespace", "mariadb", writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring("REVISION"))
+		Expect(writer.String()).To(MatchRegexp(`1 .*mariadb +6.12.2 +install`))
+		Expect(writer.String()).To(MatchRegexp(`2 .*mariadb +6.12.2 +upgrade`))
+	})
+
+	It("rolls back to a previous revision", func() {
+		applyWith("v1")
+		applyWith("v2")
+		Expect(configMap()).To(ContainSubstring(`"key":"v2"`))
+		err := Rollback(thread, NewRepo(), k, "namespace", "mariadb", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMap()).To(ContainSubstring(`"key":"v1"`))
+		Expect(configMap()).To(ContainSubstring(`"revision":"3"`))
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.History).To(HaveLen(3))
+		Expect(inv.History[2].Description).To(Equal("rollback to 1"))
+	})
+
+	It("rolls back charts with credentials in sub charts", func() {
+		dir.MkdirAll("sub", 0755)
+		dir.WriteFile("sub/Chart.star", []byte("def init(self):\n  self.db = user_credential(\"db\")\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.sub = chart(\"sub\")\n"), 0644)
+		applyWith("v1")
+		applyWith("v2")
+		err := Rollback(thread, NewRepo(), k, "namespace", "mariadb", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMap()).To(ContainSubstring(`"key":"v1"`))
+	})
+
+	It("keeps at most history-max revisions", func() {
+		for _, key := range []string{"v1", "v2", "v3"} {
+			c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"), WithHistoryMax(2))
+			Expect(err).NotTo(HaveOccurred())
+			c.SetField("key", starlark.String(key))
+			Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		}
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.History).To(HaveLen(2))
+		Expect(inv.History[0].Revision).To(Equal(2))
+		err = k.Get("secret", revisionSecretName("mariadb", 1), &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(k.IsNotExist(err)).To(BeTrue())
+		_, err = readRevision(k, "mariadb", 2)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("doesn't store credentials in revisions", func() {
+		dir.MkdirAll("sub", 0755)
+		dir.WriteFile("sub/Chart.star", []byte("def init(self):\n  self.ca = certificate(\"ca\", is_ca=True, dns_names=[\"ca.com\"])\n  self.replicas = 1\n"), 0644)
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.sub = chart(\"sub\")\n  self.db = user_credential(\"db\")\n"), 0644)
+		applyWith("v1")
+		spec, err := readRevision(k, "mariadb", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(map[string]interface{}(spec.Values)).To(HaveKeyWithValue("key", "v1"))
+		Expect(map[string]interface{}(spec.Values)).NotTo(HaveKey("db"))
+		Expect(spec.Values["sub"]).To(Equal(map[string]interface{}{"replicas": float64(1)}))
+	})
+
+	It("reports unknown revisions", func() {
+		applyWith("v1")
+		err := Rollback(thread, NewRepo(), k, "namespace", "mariadb", 5)
+		Expect(err).To(HaveOccurred())
+		err = History(k, "namespace", "unknown", &bytes.Buffer{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("deletes all revisions", func() {
+		applyWith("v1")
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		err = k.Get("secret", revisionSecretName("mariadb", 1), &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(k.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,210 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	inventoryKey        = "inventory"
+	inventorySecretType = "shalm.kramerul.github.com/release"
+)
+
+// objectRef identifies a kubernetes object, which was applied by a chart
+type objectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// inventory contains all objects and revisions of a release
+type inventory struct {
+	Objects []objectRef    `json:"objects"`
+	History []revisionInfo `json:"history,omitempty"`
+}
+
+func inventorySecretName(name string) string {
+	return "shalm." + name
+}
+
+func (o objectRef) name() string {
+	return objectName(o.Kind, o.Namespace, o.Name)
+}
+
+func (o objectRef) delete(k K8s) error {
+	return k.ForNamespace(o.Namespace).DeleteObject(kindArg(o.APIVersion, o.Kind), o.Name, &K8sOptions{Namespaced: o.Namespace != ""})
+}
+
+func objectRefs(reader io.Reader) ([]objectRef, error) {
+	objects, err := decodeObjects(reader)
+	if err != nil {
+		return nil, err
+	}
+	var result []objectRef
+	for _, obj := range objects {
+		result = append(result, objectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()})
+	}
+	return result, nil
+}
+
+// objectKey identifies an object independent of the version of its api group
+type objectKey struct {
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (o objectRef) key() objectKey {
+	group := ""
+	if index := strings.LastIndex(o.APIVersion, "/"); index >= 0 {
+		group = o.APIVersion[:index]
+	}
+	return objectKey{Group: group, Kind: o.Kind, Namespace: o.Namespace, Name: o.Name}
+}
+
+// without returns all objects, which are not contained in others
+func (i *inventory) without(others []objectRef) []objectRef {
+	contained := make(map[objectKey]bool)
+	for _, o := range others {
+		contained[o.key()] = true
+	}
+	var result []objectRef
+	for _, o := range i.Objects {
+		if !contained[o.key()] {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// add adds objects to the inventory. Objects, which are already contained, are updated to the new version
+func (i *inventory) add(objects []objectRef) {
+	index := make(map[objectKey]int)
+	for n, o := range i.Objects {
+		index[o.key()] = n
+	}
+	for _, o := range objects {
+		if n, ok := index[o.key()]; ok {
+			i.Objects[n] = o
+			continue
+		}
+		index[o.key()] = len(i.Objects)
+		i.Objects = append(i.Objects, o)
+	}
+}
+
+// readInventory reads the inventory stored in namespace. A missing inventory is returned as empty inventory
+func readInventory(k K8s, name string) (*inventory, error) {
+	var buffer bytes.Buffer
+	result := &inventory{}
+	err := k.Get("secret", inventorySecretName(name), &buffer, &K8sOptions{Namespaced: true})
+	if err != nil {
+		if k.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	if buffer.Len() == 0 {
+		return result, nil
+	}
+	var secret corev1.Secret
+	if _, _, err = serializer.Decode(buffer.Bytes(), nil, &secret); err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[inventoryKey]
+	if !ok {
+		return result, nil
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func writeInventory(k K8s, namespace string, name string, inv *inventory) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      inventorySecretName(name),
+			Namespace: namespace,
+		},
+		Type: inventorySecretType,
+		Data: map[string][]byte{inventoryKey: data},
+	}
+	return k.Apply(func(writer io.Writer) error {
+		return serializer.Encode(secret, writer)
+	}, &K8sOptions{FieldManager: "shalm"})
+}
+
+func deleteInventory(k K8s, name string) error {
+	return k.DeleteObject("secret", inventorySecretName(name), &K8sOptions{Namespaced: true})
+}
+
+// collectRendered returns all objects, which were rendered during apply or delete by this chart and all its subcharts
+func (c *chartImpl) collectRendered() []objectRef {
+	var result []objectRef
+	c.eachSubChart(func(subChart *chartImpl) error {
+		result = append(result, subChart.collectRendered()...)
+		return nil
+	})
+	return append(result, c.rendered...)
+}
+
+func (c *chartImpl) resetRendered() {
+	c.eachSubChart(func(subChart *chartImpl) error {
+		subChart.resetRendered()
+		return nil
+	})
+	c.rendered = nil
+}
+
+// updateInventory prunes all objects from the previous inventory, which are no longer rendered and stores the new inventory
+func (c *chartImpl) updateInventory(k K8s, old *inventory) error {
+	k = k.ForNamespace(c.namespace)
+	rendered := c.collectRendered()
+	if c.prune {
+		orphans := old.without(rendered)
+		for i := len(orphans) - 1; i >= 0; i-- {
+			if err := orphans[i].delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	inv := &inventory{History: old.History}
+	if !c.prune {
+		inv.add(old.Objects)
+	}
+	inv.add(rendered)
+	return writeInventory(k, c.namespace, c.GetName(), inv)
+}
+
+// deleteInventory deletes all objects and revisions from the inventory, which were not deleted yet
+func (c *chartImpl) deleteInventory(k K8s, old *inventory) error {
+	k = k.ForNamespace(c.namespace)
+	orphans := old.without(c.collectRendered())
+	for i := len(orphans) - 1; i >= 0; i-- {
+		if err := orphans[i].delete(k); err != nil {
+			return err
+		}
+	}
+	for _, r := range old.History {
+		if err := k.DeleteObject("secret", revisionSecretName(c.GetName(), r.Revision), &K8sOptions{Namespaced: true}); err != nil {
+			return err
+		}
+	}
+	return deleteInventory(k, c.GetName())
+}
@@ -0,0 +1,123 @@
+package shalm
+
+import (
+	"bytes"
+	"os"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Inventory", func() {
+	var dir TestDir
+	var k *k8sNativeImpl
+	thread := &starlark.Thread{Name: "main"}
+
+	configMap := func(name string) []byte {
+		return []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\ndata:\n  key: value\n")
+	}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("templates/cm1.yaml", configMap("cm1"), 0644)
+		dir.WriteFile("templates/cm2.yaml", configMap("cm2"), 0644)
+		k = newFakeK8sNative()
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	exists := func(name string) bool {
+		err := k.Get("configmap", name, &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		if err != nil {
+			Expect(k.IsNotExist(err)).To(BeTrue())
+			return false
+		}
+		return true
+	}
+
+	It("stores the applied objects", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		err = c.Apply(thread, k)
+		Expect(err).NotTo(HaveOccurred())
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.Objects).To(ConsistOf(
+			objectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "namespace", Name: "cm1"},
+			objectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "namespace", Name: "cm2"},
+		))
+	})
+
+	It("prunes objects, which are no longer rendered", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		os.Remove(dir.Join("templates", "cm2.yaml"))
+		c, err = newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(exists("cm1")).To(BeTrue())
+		Expect(exists("cm2")).To(BeFalse())
+	})
+
+	It("keeps objects, if pruning is disabled", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		os.Remove(dir.Join("templates", "cm2.yaml"))
+		c, err = newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"), WithPrune(false))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(exists("cm2")).To(BeTrue())
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.Objects).To(HaveLen(2))
+	})
+
+	It("deletes all objects of the inventory", func() {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		os.Remove(dir.Join("templates", "cm2.yaml"))
+		c, err = newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		Expect(exists("cm1")).To(BeFalse())
+		Expect(exists("cm2")).To(BeFalse())
+		err = k.Get("secret", inventorySecretName("mariadb"), &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(k.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("stores objects applied with k8s.apply", func() {
+		dir.WriteFile("Chart.star", []byte(`
+def apply(self,k8s):
+  self.__apply(k8s)
+  k8s.apply({"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cm3"}})
+`), 0644)
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(exists("cm3")).To(BeTrue())
+		inv, err := readInventory(k, "mariadb")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inv.Objects).To(ContainElement(objectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "namespace", Name: "cm3"}))
+		Expect(c.Delete(thread, k)).NotTo(HaveOccurred())
+		Expect(exists("cm3")).To(BeFalse())
+	})
+
+	It("ignores the api version when comparing objects", func() {
+		old := objectRef{APIVersion: "apps/v1beta2", Kind: "Deployment", Namespace: "namespace", Name: "app"}
+		updated := objectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "namespace", Name: "app"}
+		other := objectRef{APIVersion: "extensions/v1beta1", Kind: "Deployment", Namespace: "namespace", Name: "app"}
+		inv := &inventory{Objects: []objectRef{old}}
+		Expect(inv.without([]objectRef{updated})).To(BeEmpty())
+		Expect(inv.without([]objectRef{other})).To(ConsistOf(old))
+		inv.add([]objectRef{updated})
+		Expect(inv.Objects).To(ConsistOf(updated))
+	})
+})
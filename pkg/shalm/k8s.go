@@ -4,18 +4,20 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
 
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
 	"github.com/pkg/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 // NewK8s create new instance to interact with kubernetes
 func NewK8s() K8s {
-	return &k8sImpl{}
+	return &k8sImpl{capabilities: &capabilitiesCache{}}
 }
 
 // NewK8sFromContent create new instance to interact with kubernetes
@@ -27,20 +29,25 @@ func NewK8sFromContent(kubeConfig string) (K8s, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &k8sImpl{kubeconfig: &kubeconfig}, nil
+	return &k8sImpl{kubeconfig: &kubeconfig, capabilities: &capabilitiesCache{}}, nil
 }
 
 // k8sImpl -
 type k8sImpl struct {
-	namespace  string
-	kubeconfig *string
-	cmd        string
+	namespace      string
+	kubeconfig     *string
+	cmd            string
+	serverSide     bool
+	forceConflicts bool
+	capabilities   *capabilitiesCache
 }
 
 var (
 	_ K8s = (*k8sImpl)(nil)
 )
 
+var errForceConflictsClientSide = errors.New("force_conflicts requires server-side apply (--server-side)")
+
 func (k *k8sImpl) Inspect() string {
 	if k.kubeconfig != nil {
 		return "kubeconfig = " + *k.kubeconfig + " namespace = " + k.namespace
@@ -50,11 +57,41 @@ func (k *k8sImpl) Inspect() string {
 
 // Apply -
 func (k *k8sImpl) Apply(output func(io.Writer) error, options *K8sOptions) error {
-	return k.run("apply", output, options)
+	if !k.serverSide {
+		if options.ForceConflicts {
+			return errForceConflictsClientSide
+		}
+		return k.run("apply", output, options)
+	}
+	flags := []string{"--server-side"}
+	if options.FieldManager != "" {
+		flags = append(flags, "--field-manager", options.FieldManager)
+	}
+	if options.ForceConflicts || k.forceConflicts {
+		flags = append(flags, "--force-conflicts")
+	}
+	return k.run("apply", output, options, flags...)
 }
+
+// Capabilities discovers the kubernetes version and api versions of the cluster kubectl uses
+func (k *k8sImpl) Capabilities() (*renderer.Capabilities, error) {
+	return k.capabilities.get(func() (discovery.DiscoveryInterface, error) {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if k.kubeconfig != nil {
+			rules.ExplicitPath = *k.kubeconfig
+		}
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		return discovery.NewDiscoveryClientForConfig(config)
+	})
+}
+
 func (k *k8sImpl) ForNamespace(namespace string) K8s {
-	result := &k8sImpl{namespace: namespace, kubeconfig: k.kubeconfig}
-	return result
+	result := *k
+	result.namespace = namespace
+	return &result
 }
 
 // Delete -
@@ -112,15 +149,7 @@ func (k *k8sImpl) IsNotExist(err error) bool {
 
 // KubeConfigContent -
 func (k *k8sImpl) KubeConfigContent() *string {
-	if k.kubeconfig == nil {
-		return nil
-	}
-	data, err := ioutil.ReadFile(*k.kubeconfig)
-	if err != nil {
-		return nil
-	}
-	content := string(data)
-	return &content
+	return kubeConfigContent(k.kubeconfig)
 }
 
 func run(cmd *exec.Cmd) error {
@@ -0,0 +1,35 @@
+package shalm
+
+import (
+	"github.com/spf13/pflag"
+)
+
+// K8sConfigs -
+type K8sConfigs struct {
+	native         bool
+	serverSide     bool
+	forceConflicts bool
+}
+
+// AddFlags -
+func (v *K8sConfigs) AddFlags(flagsSet *pflag.FlagSet) {
+	flagsSet.BoolVar(&v.native, "native", false, "Use client-go instead of kubectl to interact with kubernetes")
+	flagsSet.BoolVar(&v.serverSide, "server-side", false, "Use server-side apply with a field manager per chart")
+	flagsSet.BoolVar(&v.forceConflicts, "force-conflicts", false, "Take ownership of fields managed by others during server-side apply (implies --server-side)")
+}
+
+// K8s creates a new instance to interact with kubernetes, either native or using kubectl
+func (v *K8sConfigs) K8s() (K8s, error) {
+	serverSide := v.serverSide || v.forceConflicts
+	if v.native {
+		k, err := NewK8sNativeFromContent("")
+		if err != nil {
+			return nil, err
+		}
+		native := k.(*k8sNativeImpl)
+		native.serverSide = serverSide
+		native.forceConflicts = v.forceConflicts
+		return native, nil
+	}
+	return &k8sImpl{serverSide: serverSide, forceConflicts: v.forceConflicts, capabilities: &capabilitiesCache{}}, nil
+}
@@ -0,0 +1,602 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/mergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewK8sNative create new instance to interact with kubernetes using client-go
+func NewK8sNative(config *rest.Config) (K8s, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cached := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	return &k8sNativeImpl{
+		client:          client,
+		mapper:          restmapper.NewShortcutExpander(cached, discoveryClient),
+		reset:           cached.Reset,
+		discoveryClient: discoveryClient,
+		capabilities:    &capabilitiesCache{},
+	}, nil
+}
+
+// NewK8sNativeFromContent create new instance to interact with kubernetes using client-go
+func NewK8sNativeFromContent(kubeConfig string) (K8s, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	var kubeconfig *string
+	if kubeConfig != "" {
+		filename, err := kubeConfigFromContent(kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		rules.ExplicitPath = filename
+		kubeconfig = &filename
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	k, err := NewK8sNative(config)
+	if err != nil {
+		return nil, err
+	}
+	impl := k.(*k8sNativeImpl)
+	impl.kubeconfig = kubeconfig
+	impl.contextNamespace, _, err = clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	return impl, nil
+}
+
+// k8sNativeImpl -
+type k8sNativeImpl struct {
+	namespace        string
+	contextNamespace string
+	kubeconfig       *string
+	client           dynamic.Interface
+	mapper           meta.RESTMapper
+	reset            func()
+	serverSide       bool
+	forceConflicts   bool
+	discoveryClient  discovery.DiscoveryInterface
+	capabilities     *capabilitiesCache
+}
+
+var (
+	_ K8s = (*k8sNativeImpl)(nil)
+)
+
+const defaultPollInterval = 2 * time.Second
+
+// lastAppliedConfigAnnotation is shared with kubectl apply, so that both can be used on the same objects
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+var pollInterval = defaultPollInterval
+
+func (k *k8sNativeImpl) Inspect() string {
+	if k.kubeconfig != nil {
+		return "kubeconfig = " + *k.kubeconfig + " namespace = " + k.namespace
+	}
+	return "namespace = " + k.namespace
+}
+
+// Capabilities discovers the kubernetes version and api versions of the cluster
+func (k *k8sNativeImpl) Capabilities() (*renderer.Capabilities, error) {
+	if k.discoveryClient == nil {
+		return nil, nil
+	}
+	return k.capabilities.get(func() (discovery.DiscoveryInterface, error) {
+		return k.discoveryClient, nil
+	})
+}
+
+func (k *k8sNativeImpl) ForNamespace(namespace string) K8s {
+	result := *k
+	result.namespace = namespace
+	return &result
+}
+
+// Apply -
+func (k *k8sNativeImpl) Apply(output func(io.Writer) error, options *K8sOptions) error {
+	if options.ForceConflicts && !k.serverSide {
+		return errForceConflictsClientSide
+	}
+	objects, err := k.decode(output)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		client, err := k.resourceClient(obj, options)
+		if err != nil {
+			return err
+		}
+		if k.serverSide {
+			err = k.serverSideApply(client, obj, options)
+		} else {
+			err = k.clientSideApply(client, obj, options)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientSideApply behaves like kubectl apply. The applied configuration is stored in the last-applied-configuration annotation
+// and changes are sent as three-way patch, so that fields removed from the configuration are removed from the object as well
+func (k *k8sNativeImpl) clientSideApply(client dynamic.ResourceInterface, obj *unstructured.Unstructured, options *K8sOptions) error {
+	modified, err := setLastAppliedConfiguration(obj)
+	if err != nil {
+		return err
+	}
+	current, err := client.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		_, err = client.Create(obj, metav1.CreateOptions{FieldManager: options.FieldManager})
+		if err != nil {
+			return errors.Wrapf(err, "error creating %s %s", obj.GetKind(), obj.GetName())
+		}
+		fmt.Printf("%s %s created\n", obj.GetKind(), obj.GetName())
+		return nil
+	}
+	original := []byte(current.GetAnnotations()[lastAppliedConfigAnnotation])
+	currentData, err := json.Marshal(current.Object)
+	if err != nil {
+		return err
+	}
+	patchType, patch, err := threeWayPatch(obj.GroupVersionKind(), original, modified, currentData)
+	if err != nil {
+		return errors.Wrapf(err, "error creating patch for %s %s", obj.GetKind(), obj.GetName())
+	}
+	if string(patch) == "{}" {
+		fmt.Printf("%s %s unchanged\n", obj.GetKind(), obj.GetName())
+		return nil
+	}
+	_, err = client.Patch(obj.GetName(), patchType, patch, metav1.PatchOptions{FieldManager: options.FieldManager})
+	if err != nil {
+		return errors.Wrapf(err, "error patching %s %s", obj.GetKind(), obj.GetName())
+	}
+	fmt.Printf("%s %s configured\n", obj.GetKind(), obj.GetName())
+	return nil
+}
+
+// setLastAppliedConfiguration stores the configuration of obj in the last-applied-configuration annotation like kubectl does.
+// It returns the configuration including the annotation
+func setLastAppliedConfiguration(obj *unstructured.Unstructured) ([]byte, error) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	delete(annotations, lastAppliedConfigAnnotation)
+	obj.SetAnnotations(annotations)
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+	original, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	annotations[lastAppliedConfigAnnotation] = string(original)
+	obj.SetAnnotations(annotations)
+	return json.Marshal(obj.Object)
+}
+
+// threeWayPatch uses a strategic merge patch for built-in types and a json merge patch for all other types
+func threeWayPatch(gvk schema.GroupVersionKind, original, modified, current []byte) (types.PatchType, []byte, error) {
+	versioned, err := scheme.Scheme.New(gvk)
+	if err != nil {
+		if !runtime.IsNotRegisteredError(err) {
+			return "", nil, err
+		}
+		preconditions := []mergepatch.PreconditionFunc{mergepatch.RequireKeyUnchanged("apiVersion"),
+			mergepatch.RequireKeyUnchanged("kind"), mergepatch.RequireMetadataKeyUnchanged("name")}
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current, preconditions...)
+		return types.MergePatchType, patch, err
+	}
+	lookupPatchMeta, err := strategicpatch.NewPatchMetaFromStruct(versioned)
+	if err != nil {
+		return "", nil, err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(original, modified, current, lookupPatchMeta, true)
+	return types.StrategicMergePatchType, patch, err
+}
+
+func (k *k8sNativeImpl) serverSideApply(client dynamic.ResourceInterface, obj *unstructured.Unstructured, options *K8sOptions) error {
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	fieldManager := options.FieldManager
+	if fieldManager == "" {
+		fieldManager = "shalm"
+	}
+	force := options.ForceConflicts || k.forceConflicts
+	_, err = client.Patch(obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			return conflictError(obj, fieldManager, err)
+		}
+		return errors.Wrapf(err, "error applying %s %s", obj.GetKind(), obj.GetName())
+	}
+	fmt.Printf("%s %s serverside-applied\n", obj.GetKind(), obj.GetName())
+	return nil
+}
+
+// conflictError reports the fields of an object, which are owned by another field manager
+func conflictError(obj *unstructured.Unstructured, fieldManager string, err error) error {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return err
+	}
+	var conflicts []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", cause.Field, cause.Message))
+		}
+	}
+	if len(conflicts) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s %s: field manager %s conflicts with other managers (use force_conflicts=True or --force-conflicts to take ownership):\n  %s",
+		obj.GetKind(), obj.GetName(), fieldManager, strings.Join(conflicts, "\n  "))
+}
+
+// Delete -
+func (k *k8sNativeImpl) Delete(output func(io.Writer) error, options *K8sOptions) error {
+	objects, err := k.decode(output)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		client, err := k.resourceClient(obj, options)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		err = k.delete(client, obj.GetKind(), obj.GetName(), options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteObject -
+func (k *k8sNativeImpl) DeleteObject(kind string, name string, options *K8sOptions) error {
+	client, err := k.resourceClientFor(kind, options)
+	if err != nil {
+		return err
+	}
+	return k.delete(client, kind, name, options)
+}
+
+// RolloutStatus -
+func (k *k8sNativeImpl) RolloutStatus(kind string, name string, options *K8sOptions) error {
+	client, err := k.resourceClientFor(kind, options)
+	if err != nil {
+		return err
+	}
+	return k.poll(kind, name, options, func() (bool, error) {
+		obj, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return rolloutComplete(obj)
+	})
+}
+
+// Wait -
+func (k *k8sNativeImpl) Wait(kind string, name string, condition string, options *K8sOptions) error {
+	client, err := k.resourceClientFor(kind, options)
+	if err != nil {
+		return err
+	}
+	if condition == "delete" {
+		return k.poll(kind, name, options, func() (bool, error) {
+			_, err := client.Get(name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+	}
+	if !strings.HasPrefix(condition, "condition=") {
+		return fmt.Errorf("unrecognized condition: %s", condition)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(condition, "condition="), "=", 2)
+	conditionType := parts[0]
+	conditionStatus := "true"
+	if len(parts) == 2 {
+		conditionStatus = parts[1]
+	}
+	return k.poll(kind, name, options, func() (bool, error) {
+		obj, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		if err != nil {
+			return false, err
+		}
+		for _, c := range conditions {
+			c, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(fmt.Sprint(c["type"]), conditionType) {
+				return strings.EqualFold(fmt.Sprint(c["status"]), conditionStatus), nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// Get -
+func (k *k8sNativeImpl) Get(kind string, name string, writer io.Writer, options *K8sOptions) error {
+	client, err := k.resourceClientFor(kind, options)
+	if err != nil {
+		return err
+	}
+	obj, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(writer).Encode(obj.Object)
+}
+
+// Watch -
+func (k *k8sNativeImpl) Watch(kind string, name string, options *K8sOptions) (io.ReadCloser, error) {
+	client, err := k.resourceClientFor(kind, options)
+	if err != nil {
+		return nil, err
+	}
+	w, err := client.Watch(metav1.ListOptions{FieldSelector: "metadata.name=" + name})
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		encoder := json.NewEncoder(writer)
+		for event := range w.ResultChan() {
+			obj, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			if err := encoder.Encode(obj.Object); err != nil {
+				break
+			}
+		}
+		writer.Close()
+	}()
+	return &watchReader{PipeReader: reader, stop: w.Stop}, nil
+}
+
+// IsNotExist -
+func (k *k8sNativeImpl) IsNotExist(err error) bool {
+	return apierrors.IsNotFound(errors.Cause(err))
+}
+
+// KubeConfigContent -
+func (k *k8sNativeImpl) KubeConfigContent() *string {
+	return kubeConfigContent(k.kubeconfig)
+}
+
+type watchReader struct {
+	*io.PipeReader
+	stop func()
+}
+
+func (w *watchReader) Close() error {
+	w.stop()
+	return w.PipeReader.Close()
+}
+
+func (k *k8sNativeImpl) decode(output func(io.Writer) error) ([]*unstructured.Unstructured, error) {
+	var buffer bytes.Buffer
+	if err := output(&buffer); err != nil {
+		return nil, err
+	}
+	return decodeObjects(&buffer)
+}
+
+func (k *k8sNativeImpl) delete(client dynamic.ResourceInterface, kind string, name string, options *K8sOptions) error {
+	propagation := metav1.DeletePropagationBackground
+	err := client.Delete(name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "error deleting %s %s", kind, name)
+	}
+	fmt.Printf("%s %s deleted\n", kind, name)
+	return k.poll(kind, name, options, func() (bool, error) {
+		_, err := client.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+func (k *k8sNativeImpl) poll(kind string, name string, options *K8sOptions, condition func() (bool, error)) error {
+	start := time.Now()
+	for {
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if options.Timeout > 0 {
+			if time.Since(start) > options.Timeout {
+				return fmt.Errorf("Timeout during waiting for %s %s", kind, name)
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func (k *k8sNativeImpl) defaultNamespace(namespaced bool) string {
+	if namespaced && k.namespace != "" {
+		return k.namespace
+	}
+	if k.contextNamespace != "" {
+		return k.contextNamespace
+	}
+	return "default"
+}
+
+func (k *k8sNativeImpl) resourceClient(obj *unstructured.Unstructured, options *K8sOptions) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := k.restMapping(func() (*meta.RESTMapping, error) {
+		return k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return k.client.Resource(mapping.Resource), nil
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" || options.Namespaced {
+		namespace = k.defaultNamespace(true)
+		obj.SetNamespace(namespace)
+	}
+	return k.client.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+func (k *k8sNativeImpl) resourceClientFor(kind string, options *K8sOptions) (dynamic.ResourceInterface, error) {
+	mapping, err := k.restMapping(func() (*meta.RESTMapping, error) {
+		return k.mappingFor(kind)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return k.client.Resource(mapping.Resource), nil
+	}
+	return k.client.Resource(mapping.Resource).Namespace(k.defaultNamespace(options.Namespaced)), nil
+}
+
+// restMapping retries once with a fresh discovery cache, because CRDs might be created during apply
+func (k *k8sNativeImpl) restMapping(mapping func() (*meta.RESTMapping, error)) (*meta.RESTMapping, error) {
+	result, err := mapping()
+	if err != nil && meta.IsNoMatchError(err) && k.reset != nil {
+		k.reset()
+		return mapping()
+	}
+	return result, err
+}
+
+// mappingFor accepts the same kind strings as kubectl (e.g. "statefulset", "pvc", "deployments.v1.apps" or "Deployment.v1.apps")
+func (k *k8sNativeImpl) mappingFor(kind string) (*meta.RESTMapping, error) {
+	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(strings.ToLower(kind))
+	gvk := schema.GroupVersionKind{}
+	if fullySpecifiedGVR != nil {
+		gvk, _ = k.mapper.KindFor(*fullySpecifiedGVR)
+	}
+	if gvk.Empty() {
+		gvk, _ = k.mapper.KindFor(groupResource.WithVersion(""))
+	}
+	if !gvk.Empty() {
+		return k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	fullySpecifiedGVK, groupKind := schema.ParseKindArg(kind)
+	if fullySpecifiedGVK == nil {
+		gvk := groupKind.WithVersion("")
+		fullySpecifiedGVK = &gvk
+	}
+	if !fullySpecifiedGVK.Empty() {
+		if mapping, err := k.mapper.RESTMapping(fullySpecifiedGVK.GroupKind(), fullySpecifiedGVK.Version); err == nil {
+			return mapping, nil
+		}
+	}
+	mapping, err := k.mapper.RESTMapping(groupKind, gvk.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("the server doesn't have a resource type %q", groupResource.Resource)
+	}
+	return mapping, nil
+}
+
+func rolloutComplete(obj *unstructured.Unstructured) (bool, error) {
+	generation := obj.GetGeneration()
+	observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if generation > observedGeneration {
+		return false, nil
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	status := func(field string) int64 {
+		value, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		return value
+	}
+	switch obj.GetKind() {
+	case "Deployment":
+		updated := status("updatedReplicas")
+		return updated >= replicas && status("replicas") <= updated && status("availableReplicas") >= updated, nil
+	case "StatefulSet":
+		if status("readyReplicas") < replicas {
+			return false, nil
+		}
+		updateRevision, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		currentRevision, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+		if strategy == "OnDelete" {
+			return true, nil
+		}
+		partition, found, _ := unstructured.NestedInt64(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition")
+		if found && partition > 0 {
+			return status("updatedReplicas") >= replicas-partition, nil
+		}
+		return updateRevision == currentRevision, nil
+	case "DaemonSet":
+		desired := status("desiredNumberScheduled")
+		return status("updatedNumberScheduled") >= desired && status("numberAvailable") >= desired, nil
+	default:
+		return false, fmt.Errorf("no status viewer has been implemented for %s", obj.GetKind())
+	}
+}
@@ -0,0 +1,346 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func newFakeK8sNative(objects ...runtime.Object) *k8sNativeImpl {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, meta.RESTScopeNamespace)
+	return &k8sNativeImpl{
+		namespace: "namespace",
+		client:    newFakeDynamicClient(objects...),
+		mapper:    mapper,
+	}
+}
+
+// newFakeDynamicClient returns a fake client, which applies strategic merge patches to built-in types like the api server.
+// The tracker of fake.NewSimpleDynamicClient can't apply them to unstructured objects
+func newFakeDynamicClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+	s := runtime.NewScheme()
+	tracker := k8stesting.NewObjectTracker(s, runtimeserializer.NewCodecFactory(s).UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+	client := fake.NewSimpleDynamicClient(s)
+	client.ReactionChain = nil
+	client.WatchReactionChain = nil
+	client.AddReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchActionImpl)
+		if patch.GetPatchType() != types.StrategicMergePatchType {
+			return false, nil, nil
+		}
+		obj, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		current := obj.(*unstructured.Unstructured)
+		versioned, err := scheme.Scheme.New(current.GroupVersionKind())
+		if err != nil {
+			return true, nil, err
+		}
+		data, err := json.Marshal(current.Object)
+		if err != nil {
+			return true, nil, err
+		}
+		patched, err := strategicpatch.StrategicMergePatch(data, patch.GetPatch(), versioned)
+		if err != nil {
+			return true, nil, err
+		}
+		result := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patched, &result.Object); err != nil {
+			return true, nil, err
+		}
+		return true, result, tracker.Update(patch.GetResource(), result, patch.GetNamespace())
+	})
+	client.AddReactor("*", "*", k8stesting.ObjectReaction(tracker))
+	client.AddWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+		return err == nil, w, err
+	})
+	return client
+}
+
+func unstructuredObject(apiVersion string, kind string, namespace string, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for k, v := range fields {
+		obj.Object[k] = v
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func writeString(content string) func(io.Writer) error {
+	return func(writer io.Writer) error {
+		_, err := writer.Write([]byte(content))
+		return err
+	}
+}
+
+func k8sBehaviour(newK8s func() K8s) {
+	var k K8s
+	configMap := "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n  namespace: namespace\ndata:\n  key: value\n"
+
+	BeforeEach(func() {
+		k = newK8s()
+	})
+
+	It("apply works", func() {
+		err := k.Apply(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		writer := &bytes.Buffer{}
+		err = k.Get("configmap", "test", writer, &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring(`"key":"value"`))
+
+		By("updating existing objects")
+		err = k.Apply(writeString(configMap+"  key2: value2\n"), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		writer.Reset()
+		err = k.Get("configmaps", "test", writer, &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring(`"key2":"value2"`))
+	})
+	It("apply removes fields, which are no longer applied", func() {
+		err := k.Apply(writeString("---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n  namespace: namespace\n  labels:\n    app: test\ndata:\n  key: value\n  key2: value2\n"), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.Apply(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		writer := &bytes.Buffer{}
+		err = k.Get("configmap", "test", writer, &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring(`"key":"value"`))
+		Expect(writer.String()).NotTo(ContainSubstring(`key2`))
+		Expect(writer.String()).NotTo(ContainSubstring(`"app":"test"`))
+		Expect(writer.String()).To(ContainSubstring(lastAppliedConfigAnnotation))
+	})
+	It("delete works", func() {
+		err := k.Apply(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.Delete(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.Get("configmap", "test", &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(err).To(HaveOccurred())
+		Expect(k.IsNotExist(err)).To(BeTrue())
+
+		By("ignoring objects which don't exist")
+		err = k.Delete(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("delete object works", func() {
+		err := k.Apply(writeString(configMap), &K8sOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.DeleteObject("configmap", "test", &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.DeleteObject("configmap", "test", &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("get reports not existing objects", func() {
+		err := k.Get("secret", "unknown", &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(err).To(HaveOccurred())
+		Expect(k.IsNotExist(err)).To(BeTrue())
+	})
+	It("for namespace works", func() {
+		err := k.ForNamespace("namespace").Apply(writeString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+		err = k.ForNamespace("namespace").Get("configmap", "test", &bytes.Buffer{}, &K8sOptions{Namespaced: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+}
+
+var _ = Describe("k8s native", func() {
+
+	Context("fake client", func() {
+		k8sBehaviour(func() K8s { return newFakeK8sNative() })
+
+		It("apply keeps fields of custom resources, which are set by others", func() {
+			widget := "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: test\n  namespace: namespace\nspec:\n  size: 1\n  color: red\n"
+			k := newFakeK8sNative()
+			k.mapper.(*meta.DefaultRESTMapper).Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, meta.RESTScopeNamespace)
+			Expect(k.Apply(writeString(widget), &K8sOptions{})).NotTo(HaveOccurred())
+			gvr := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+			_, err := k.client.Resource(gvr).Namespace("namespace").Patch("test", types.MergePatchType, []byte(`{"spec":{"owner":"other"}}`), metav1.PatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k.Apply(writeString(strings.Replace(widget, "  color: red\n", "", 1)), &K8sOptions{})).NotTo(HaveOccurred())
+			obj, err := k.client.Resource(gvr).Namespace("namespace").Get("test", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj.Object["spec"]).To(Equal(map[string]interface{}{"size": int64(1), "owner": "other"}))
+		})
+		It("passes discovered capabilities to helm templates", func() {
+			thread := &starlark.Thread{Name: "main"}
+			dir := NewTestDir()
+			defer dir.Remove()
+			dir.MkdirAll("templates", 0755)
+			dir.WriteFile("templates/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\ndata:\n  version: {{ .Capabilities.KubeVersion.Version }}\n  widget: \"{{ .Capabilities.APIVersions.Has \"example.com/v1/Widget\" }}\"\n"), 0644)
+			k := newFakeK8sNative()
+			k.capabilities = &capabilitiesCache{}
+			k.discoveryClient = &fakediscovery.FakeDiscovery{
+				Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+					{GroupVersion: "example.com/v1", APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget"}}},
+				}},
+				FakedServerVersion: &version.Info{GitVersion: "v1.18.2", Major: "1", Minor: "18"},
+			}
+			c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Apply(thread, k)).NotTo(HaveOccurred())
+			obj, err := k.client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).Namespace("namespace").Get("test", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"version": "v1.18.2", "widget": "true"}))
+			output, err := c.Template(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainSubstring("version: v1.16.0"))
+		})
+		It("rollout status works", func() {
+			k := newFakeK8sNative(unstructuredObject("apps/v1", "Deployment", "namespace", "test", map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"replicas": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2)},
+			}))
+			err := k.RolloutStatus("deployment", "test", &K8sOptions{Namespaced: true})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("rollout status times out", func() {
+			k := newFakeK8sNative(unstructuredObject("apps/v1", "StatefulSet", "namespace", "test", map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"readyReplicas": int64(1)},
+			}))
+			pollInterval = 0
+			defer func() { pollInterval = defaultPollInterval }()
+			err := k.RolloutStatus("statefulsets.apps", "test", &K8sOptions{Namespaced: true, Timeout: 1})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Timeout"))
+		})
+		It("wait works", func() {
+			k := newFakeK8sNative(unstructuredObject("apps/v1", "Deployment", "namespace", "test", map[string]interface{}{
+				"status": map[string]interface{}{"conditions": []interface{}{
+					map[string]interface{}{"type": "Available", "status": "True"},
+				}},
+			}))
+			err := k.Wait("Deployment.v1.apps", "test", "condition=available", &K8sOptions{Namespaced: true})
+			Expect(err).NotTo(HaveOccurred())
+			err = k.Wait("deployment", "unknown", "delete", &K8sOptions{Namespaced: true})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("watch works", func() {
+			k := newFakeK8sNative(unstructuredObject("v1", "ConfigMap", "namespace", "test", nil))
+			reader, err := k.Watch("configmap", "test", &K8sOptions{Namespaced: true})
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+			err = k.Apply(writeString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test2\n  namespace: namespace\n"), &K8sOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var obj map[string]interface{}
+			err = json.NewDecoder(reader).Decode(&obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj["kind"]).To(Equal("ConfigMap"))
+		})
+		It("doesn't set namespace on cluster scoped objects", func() {
+			k := newFakeK8sNative()
+			err := k.Apply(writeString("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n"), &K8sOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			writer := &bytes.Buffer{}
+			err = k.Get("namespace", "test", writer, &K8sOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writer.String()).NotTo(ContainSubstring(`"namespace":`))
+		})
+
+		It("rejects force_conflicts without server-side apply", func() {
+			k := newFakeK8sNative()
+			err := k.Apply(writeString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), &K8sOptions{ForceConflicts: true})
+			Expect(err).To(MatchError(ContainSubstring("--server-side")))
+		})
+
+		Context("server-side apply", func() {
+			var k *k8sNativeImpl
+			var client *fake.FakeDynamicClient
+			var patches []k8stesting.PatchActionImpl
+
+			BeforeEach(func() {
+				k = newFakeK8sNative()
+				k.serverSide = true
+				client = k.client.(*fake.FakeDynamicClient)
+				patches = nil
+			})
+
+			It("uses apply patches with field manager", func() {
+				client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					patches = append(patches, action.(k8stesting.PatchActionImpl))
+					return true, unstructuredObject("v1", "ConfigMap", "namespace", "test", nil), nil
+				})
+				err := k.Apply(writeString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), &K8sOptions{FieldManager: "shalm/test"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(patches).To(HaveLen(1))
+				Expect(patches[0].GetPatchType()).To(Equal(types.ApplyPatchType))
+				Expect(patches[0].GetNamespace()).To(Equal("namespace"))
+				Expect(string(patches[0].GetPatch())).To(ContainSubstring(`"kind":"ConfigMap"`))
+			})
+
+			It("reports conflicting fields", func() {
+				client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewApplyConflict([]metav1.StatusCause{
+						{Type: metav1.CauseTypeFieldManagerConflict, Field: ".data.key", Message: `conflict with "kubectl"`},
+					}, "Apply failed with 1 conflict")
+				})
+				err := k.Apply(writeString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), &K8sOptions{FieldManager: "shalm/test"})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("shalm/test"))
+				Expect(err.Error()).To(ContainSubstring(`.data.key: conflict with "kubectl"`))
+			})
+		})
+	})
+
+	Context("envtest", func() {
+		var testEnv *envtest.Environment
+		k8sBehaviour(func() K8s {
+			if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+				Skip("KUBEBUILDER_ASSETS not set")
+			}
+			testEnv = &envtest.Environment{}
+			cfg, err := testEnv.Start()
+			Expect(err).NotTo(HaveOccurred())
+			k, err := NewK8sNative(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			err = k.Apply(writeString("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: namespace\n"), &K8sOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return k.ForNamespace("namespace")
+		})
+		AfterEach(func() {
+			if testEnv != nil {
+				testEnv.Stop()
+				testEnv = nil
+			}
+		})
+	})
+})
@@ -16,6 +16,15 @@ var _ = Describe("k8s", func() {
 		err := k8s.Apply(func(writer io.Writer) error { return nil }, &K8sOptions{})
 		Expect(err).NotTo(HaveOccurred())
 	})
+	It("server-side apply works", func() {
+		k8s := k8sImpl{cmd: "echo", serverSide: true}
+		err := k8s.Apply(func(writer io.Writer) error { return nil }, &K8sOptions{FieldManager: "shalm/test", ForceConflicts: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("rejects force_conflicts without server-side apply", func() {
+		err := k8s.Apply(func(writer io.Writer) error { return nil }, &K8sOptions{ForceConflicts: true})
+		Expect(err).To(MatchError(ContainSubstring("--server-side")))
+	})
 	It("delete works", func() {
 		err := k8s.Delete(func(writer io.Writer) error { return nil }, &K8sOptions{})
 		Expect(err).NotTo(HaveOccurred())
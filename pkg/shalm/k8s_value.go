@@ -9,17 +9,20 @@ import (
 	"io"
 	"time"
 
+	"github.com/kramerul/shalm/pkg/shalm/renderer"
 	"go.starlark.net/starlark"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // NewK8sValue create new instance to interact with kubernetes
 func NewK8sValue(k K8s) K8sValue {
-	return &k8sValueImpl{k}
+	return &k8sValueImpl{K8s: k}
 }
 
 type k8sValueImpl struct {
 	K8s
+	// chart is set inside apply and delete of a chart. Objects applied by k8s.apply belong to this chart
+	chart *chartImpl
 }
 
 type k8sWatcher struct {
@@ -49,7 +52,7 @@ func makeK8sValue(thread *starlark.Thread, fn *starlark.Builtin, args starlark.T
 	if err != nil {
 		return starlark.None, err
 	}
-	return &k8sValueImpl{&k8sImpl{kubeconfig: &kubeconfig, namespace: namespace}}, nil
+	return &k8sValueImpl{K8s: &k8sImpl{kubeconfig: &kubeconfig, namespace: namespace}}, nil
 }
 
 // String -
@@ -96,6 +99,42 @@ func (k *k8sValueImpl) Attr(name string) (starlark.Value, error) {
 			return starlark.None, k.Wait(kind, name, condition, k8sOptions)
 		}), nil
 	}
+	if name == "apply" {
+		return starlark.NewBuiltin("apply", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
+			var obj starlark.Value
+			parser := &kwargsParser{kwargs: kwargs}
+			k8sOptions := unpackK8sOptions(parser)
+			if err := starlark.UnpackArgs("apply", args, parser.Parse(), "obj", &obj); err != nil {
+				return nil, err
+			}
+			objects, err := toObjects(starlark.NewList([]starlark.Value{obj}))
+			if err != nil {
+				return nil, err
+			}
+			if k.chart == nil {
+				// The namespace of k8s is used for objects without namespace. These objects are not part of any release
+				return starlark.None, k.Apply(func(writer io.Writer) error {
+					return renderer.ObjectRender("", writer, &renderer.Options{}, objects)
+				}, k8sOptions)
+			}
+			// Objects are recorded in the inventory of the chart like objects applied by __apply
+			buffer, err := k.chart.render(thread, &renderer.Options{}, func(thread *starlark.Thread, writer io.Writer, options *renderer.Options) error {
+				return renderer.ObjectRender(k.chart.namespace, writer, options, objects)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := recordCreated(thread, k, buffer); err != nil {
+				return nil, err
+			}
+			k8sOptions.Namespaced = false
+			k8sOptions.FieldManager = k.chart.fieldManager()
+			return starlark.None, k.Apply(func(writer io.Writer) error {
+				_, err := writer.Write(buffer)
+				return err
+			}, k8sOptions)
+		}), nil
+	}
 	if name == "delete" {
 		return starlark.NewBuiltin("delete", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
 			var kind string
@@ -167,7 +206,9 @@ func (k *k8sValueImpl) Attr(name string) (starlark.Value, error) {
 }
 
 // AttrNames -
-func (k *k8sValueImpl) AttrNames() []string { return []string{"rollout_status", "delete", "get"} }
+func (k *k8sValueImpl) AttrNames() []string {
+	return []string{"rollout_status", "apply", "delete", "get"}
+}
 
 func unpackK8sOptions(parser *kwargsParser) *K8sOptions {
 	result := &K8sOptions{Namespaced: true}
@@ -14,7 +14,7 @@ import (
 var _ = Describe("K8sValue", func() {
 
 	It("behaves like starlark value", func() {
-		k8s := &k8sValueImpl{&FakeK8s{
+		k8s := &k8sValueImpl{K8s: &FakeK8s{
 			InspectStub: func() string {
 				return "kubeconfig = "
 			},
@@ -23,13 +23,13 @@ var _ = Describe("K8sValue", func() {
 		Expect(k8s.Type()).To(Equal("k8s"))
 		Expect(func() { k8s.Hash() }).Should(Panic())
 		Expect(k8s.Truth()).To(BeEquivalentTo(false))
-		for _, method := range []string{"rollout_status", "delete", "get"} {
+		for _, method := range []string{"rollout_status", "apply", "delete", "get"} {
 			value, err := k8s.Attr(method)
 			Expect(err).NotTo(HaveOccurred())
 			_, ok := value.(starlark.Callable)
 			Expect(ok).To(BeTrue())
 		}
-		Expect(k8s.AttrNames()).To(ConsistOf("rollout_status", "delete", "get"))
+		Expect(k8s.AttrNames()).To(ConsistOf("rollout_status", "apply", "delete", "get"))
 	})
 
 	It("methods behave well", func() {
@@ -39,7 +39,7 @@ var _ = Describe("K8sValue", func() {
 				return nil
 			},
 		}
-		k8s := &k8sValueImpl{fake}
+		k8s := &k8sValueImpl{K8s: fake}
 		thread := &starlark.Thread{}
 		for _, method := range []string{"rollout_status", "delete", "get"} {
 			value, err := k8s.Attr(method)
@@ -66,13 +66,35 @@ var _ = Describe("K8sValue", func() {
 		Expect(fake.GetCallCount()).To(Equal(1))
 	})
 
+	It("applies objects", func() {
+		fake := &FakeK8s{}
+		k8s := &k8sValueImpl{K8s: fake}
+		thread := &starlark.Thread{}
+		apply, err := k8s.Attr("apply")
+		Expect(err).NotTo(HaveOccurred())
+		obj := starlark.NewDict(3)
+		obj.SetKey(starlark.String("apiVersion"), starlark.String("v1"))
+		obj.SetKey(starlark.String("kind"), starlark.String("ConfigMap"))
+		obj.SetKey(starlark.String("metadata"), wrapDict(toStarlark(map[string]interface{}{"name": "cm"})))
+		_, err = starlark.Call(thread, apply, starlark.Tuple{obj}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fake.ApplyCallCount()).To(Equal(1))
+		output, options := fake.ApplyArgsForCall(0)
+		Expect(options.Namespaced).To(BeTrue())
+		writer := bytes.Buffer{}
+		Expect(output(&writer)).NotTo(HaveOccurred())
+		Expect(writer.String()).To(Equal("---\nmetadata:\n  name: cm\nkind: ConfigMap\napiVersion: v1\n"))
+		_, err = starlark.Call(thread, apply, starlark.Tuple{starlark.String("cm")}, nil)
+		Expect(err).To(MatchError(ContainSubstring("must be a dict or struct")))
+	})
+
 	It("watches objects", func() {
 		fake := &FakeK8s{
 			WatchStub: func(kind string, name string, options *K8sOptions) (closer io.ReadCloser, e error) {
 				return ioutil.NopCloser(bytes.NewReader([]byte(`{ "key" : "value" }`))), nil
 			},
 		}
-		k8s := &k8sValueImpl{fake}
+		k8s := &k8sValueImpl{K8s: fake}
 		thread := &starlark.Thread{}
 		watch, err := k8s.Attr("watch")
 		value, err := starlark.Call(thread, watch, starlark.Tuple{starlark.String("kind"), starlark.String("object")},
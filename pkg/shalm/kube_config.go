@@ -18,3 +18,15 @@ func kubeConfigFromContent(content string) (string, error) {
 	}
 	return filename, nil
 }
+
+func kubeConfigContent(kubeconfig *string) *string {
+	if kubeconfig == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*kubeconfig)
+	if err != nil {
+		return nil
+	}
+	content := string(data)
+	return &content
+}
@@ -0,0 +1,59 @@
+package renderer
+
+import (
+	"sort"
+
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Capabilities is passed as .Capabilities to helm templates
+type Capabilities struct {
+	KubeVersion KubeVersion
+	APIVersions VersionSet
+}
+
+// KubeVersion -
+type KubeVersion struct {
+	Version string
+	Major   string
+	Minor   string
+}
+
+// String -
+func (kv KubeVersion) String() string {
+	return kv.Version
+}
+
+// GitVersion is kept for compatibility with helm 2 charts
+func (kv KubeVersion) GitVersion() string {
+	return kv.Version
+}
+
+// VersionSet contains group versions (e.g. apps/v1) and resources (e.g. apps/v1/Deployment)
+type VersionSet []string
+
+// Has -
+func (v VersionSet) Has(apiVersion string) bool {
+	return contains(v, apiVersion)
+}
+
+// DefaultCapabilities are the capabilities of the kubernetes version shalm is built with. It's the same as helm template uses.
+// They are used by shalm template. Apply, delete and diff discover the capabilities of the cluster
+var DefaultCapabilities = Capabilities{
+	KubeVersion: KubeVersion{Version: "v1.16.0", Major: "1", Minor: "16"},
+	APIVersions: knownVersions(),
+}
+
+func knownVersions() VersionSet {
+	seen := make(map[string]bool)
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		seen[gvk.GroupVersion().String()] = true
+		seen[gvk.GroupVersion().String()+"/"+gvk.Kind] = true
+	}
+	result := make(VersionSet, 0, len(seen))
+	for v := range seen {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -7,6 +7,8 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -28,30 +30,124 @@ type object struct {
 type Options struct {
 	Glob           string
 	UninstallOrder bool
+	// SeparateHooks removes objects annotated with helm.sh/hook from the output and collects them in Hooks
+	SeparateHooks bool
+	Hooks         []Hook
+	// Lookup is used by the helm function lookup. Without Lookup, lookup returns an empty dict like in helm template
+	Lookup Lookup
+	// Capabilities are passed as .Capabilities to helm templates. Without Capabilities, DefaultCapabilities are used
+	Capabilities *Capabilities
+}
+
+// Hook is an object, which is annotated with helm.sh/hook
+type Hook struct {
+	APIVersion     string
+	Kind           string
+	Name           string
+	Namespace      string
+	Events         []string
+	Weight         int
+	DeletePolicies []string
+	Content        []byte
+}
+
+// HasEvent checks, if the hook is executed for event
+func (h *Hook) HasEvent(event string) bool {
+	return contains(h.Events, event)
+}
+
+// HasDeletePolicy checks, if the hook has the given delete policy
+func (h *Hook) HasDeletePolicy(policy string) bool {
+	return contains(h.DeletePolicies, policy)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func splitAnnotation(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (o *object) annotation(key string) string {
+	annotations, ok := o.MetaData.Additional["annotations"].(map[interface{}]interface{})
+	if !ok {
+		return ""
+	}
+	value, ok := annotations[key].(string)
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+func (o *object) hook() (*Hook, error) {
+	events := splitAnnotation(o.annotation("helm.sh/hook"))
+	if len(events) == 0 {
+		return nil, nil
+	}
+	content, err := yaml.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	weight, _ := strconv.Atoi(o.annotation("helm.sh/hook-weight"))
+	apiVersion, _ := o.Additional["apiVersion"].(string)
+	return &Hook{
+		APIVersion:     apiVersion,
+		Kind:           o.Kind,
+		Name:           o.MetaData.Name,
+		Namespace:      o.MetaData.Namespace,
+		Events:         events,
+		Weight:         weight,
+		DeletePolicies: splitAnnotation(o.annotation("helm.sh/hook-delete-policy")),
+		Content:        content,
+	}, nil
+}
+
+func (o *object) isEmpty() bool {
+	return o.Kind == "" && o.MetaData.Name == "" && len(o.Additional) == 0
 }
 
 func (o *object) setDefaultNamespace(namespace string) {
-	switch o.Kind {
-	case "Namespace":
+	if clusterScoped(o.Kind) {
 		return
+	}
+	if o.MetaData.Namespace == "" {
+		o.MetaData.Namespace = namespace
+	}
+}
+
+func clusterScoped(kind string) bool {
+	switch kind {
+	case "Namespace":
+		return true
 	case "ResourceQuota":
-		return
+		return true
 	case "CustomResourceDefinition":
-		return
+		return true
 	case "ClusterRole":
-		return
+		return true
 	case "ClusterRoleList":
-		return
+		return true
 	case "ClusterRoleBinding":
-		return
+		return true
 	case "ClusterRoleBindingList":
-		return
+		return true
 	case "APIService":
-		return
-	}
-	if o.MetaData.Namespace == "" {
-		o.MetaData.Namespace = namespace
+		return true
 	}
+	return false
 }
 
 func (o *object) kindOrdinal() int {
@@ -131,17 +227,24 @@ func (o *object) kindOrdinal() int {
 type DirSpec struct {
 	Dir          string
 	FileRenderer func(filename string, writer io.Writer) error
+	// Glob overrides the default glob *.y*ml, if Options.Glob is empty
+	Glob string
+	// Overlay is applied to the output of all specs after rendering
+	Overlay func(content []byte) ([]byte, error)
 }
 
 // DirRender -
 func DirRender(namespace string, writer io.Writer, opts *Options, specs ...DirSpec) error {
-	glob := "*.y*ml"
-	if opts.Glob != "" {
-		glob = opts.Glob
-	}
-	var docs []object
+	var contents [][]byte
+	var overlays []func(content []byte) ([]byte, error)
 	for _, r := range specs {
 		var filenames []string
+		glob := "*.y*ml"
+		if opts.Glob != "" {
+			glob = opts.Glob
+		} else if r.Glob != "" {
+			glob = r.Glob
+		}
 
 		err := filepath.Walk(r.Dir, func(file string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -172,13 +275,72 @@ func DirRender(namespace string, writer io.Writer, opts *Options, specs ...DirSp
 				return err
 			}
 			if buffer.Len() > 0 {
-				dec := yaml.NewDecoder(&buffer)
-				var doc object
-				for dec.Decode(&doc) == nil {
-					doc.setDefaultNamespace(namespace)
-					docs = append(docs, doc)
+				contents = append(contents, buffer.Bytes())
+			}
+		}
+		if r.Overlay != nil {
+			overlays = append(overlays, r.Overlay)
+		}
+	}
+	if len(overlays) > 0 && len(contents) > 0 {
+		content := bytes.Join(contents, []byte("\n---\n"))
+		for _, overlay := range overlays {
+			var err error
+			content, err = overlay(content)
+			if err != nil {
+				return err
+			}
+		}
+		contents = [][]byte{content}
+	}
+	return render(namespace, writer, opts, contents)
+}
+
+// ObjectRender renders objects like DirRender renders the files of a directory
+func ObjectRender(namespace string, writer io.Writer, opts *Options, objects []interface{}) error {
+	var buffer bytes.Buffer
+	if err := WriteObjects(&buffer, objects); err != nil {
+		return err
+	}
+	return render(namespace, writer, opts, [][]byte{buffer.Bytes()})
+}
+
+// WriteObjects writes objects as yaml documents
+func WriteObjects(writer io.Writer, objects []interface{}) error {
+	enc := yaml.NewEncoder(writer)
+	for _, obj := range objects {
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// render sets the default namespace, separates hooks and sorts the objects in contents
+func render(namespace string, writer io.Writer, opts *Options, contents [][]byte) error {
+	var docs []object
+	for _, content := range contents {
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var doc object
+			if dec.Decode(&doc) != nil {
+				break
+			}
+			if doc.isEmpty() {
+				continue
+			}
+			doc.setDefaultNamespace(namespace)
+			if opts.SeparateHooks {
+				hook, err := doc.hook()
+				if err != nil {
+					return err
+				}
+				if hook != nil {
+					opts.Hooks = append(opts.Hooks, *hook)
+					continue
 				}
 			}
+			docs = append(docs, doc)
 		}
 	}
 	if opts.UninstallOrder {
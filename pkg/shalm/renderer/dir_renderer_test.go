@@ -82,7 +82,7 @@ var _ = Describe("DirRender", func() {
 			dir.WriteFile("test2.yml", []byte("test: test2"), 0644)
 
 			writer := &bytes.Buffer{}
-			err = DirRender("namespace", writer, &Options{}, DirSpec{dir.Root(), fileRenderer})
+			err = DirRender("namespace", writer, &Options{}, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\ntest: test1\n---\nmetadata:\n  namespace: namespace\ntest: test2\n"))
 		})
@@ -93,7 +93,7 @@ var _ = Describe("DirRender", func() {
 			dir.WriteFile("test1.yaml", []byte("test: test1"), 0644)
 			dir.WriteFile("test3.yaml", []byte("test: test2"), 0644)
 			writer := &bytes.Buffer{}
-			err = DirRender("namespace", writer, &Options{Glob: "*[1-2].yaml"}, DirSpec{dir.Root(), fileRenderer})
+			err = DirRender("namespace", writer, &Options{Glob: "*[1-2].yaml"}, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\ntest: test1\n"))
 		})
@@ -107,7 +107,7 @@ var _ = Describe("DirRender", func() {
 
 			By("Sorts in install order")
 			writer := &bytes.Buffer{}
-			err = DirRender("namespace", writer, &Options{}, DirSpec{dir.Root(), fileRenderer})
+			err = DirRender("namespace", writer, &Options{}, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.String()).To(Equal(`---
 metadata:
@@ -125,7 +125,7 @@ kind: Other
 
 			By("Sorts in uninstall order")
 			writer = &bytes.Buffer{}
-			err = DirRender("namespace", writer, &Options{UninstallOrder: true}, DirSpec{dir.Root(), fileRenderer})
+			err = DirRender("namespace", writer, &Options{UninstallOrder: true}, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.String()).To(Equal(`---
 metadata:
@@ -141,5 +141,54 @@ metadata:
 kind: Service
 `))
 		})
+		It("separates helm hooks", func() {
+			dir := NewTestDir()
+			defer dir.Remove()
+			dir.WriteFile("job.yaml", []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install, pre-upgrade
+    helm.sh/hook-weight: "-5"
+    helm.sh/hook-delete-policy: hook-succeeded
+`), 0644)
+			dir.WriteFile("service.yaml", []byte("kind: Service"), 0644)
+
+			By("Keeps hooks by default")
+			writer := &bytes.Buffer{}
+			err := DirRender("namespace", writer, &Options{}, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.String()).To(ContainSubstring("name: migrate"))
+
+			By("Separates hooks on demand")
+			writer = &bytes.Buffer{}
+			opts := &Options{SeparateHooks: true}
+			err = DirRender("namespace", writer, opts, DirSpec{Dir: dir.Root(), FileRenderer: fileRenderer})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.String()).To(Equal("---\nmetadata:\n  namespace: namespace\nkind: Service\n"))
+			Expect(opts.Hooks).To(HaveLen(1))
+			hook := opts.Hooks[0]
+			Expect(hook.APIVersion).To(Equal("batch/v1"))
+			Expect(hook.Kind).To(Equal("Job"))
+			Expect(hook.Name).To(Equal("migrate"))
+			Expect(hook.Namespace).To(Equal("namespace"))
+			Expect(hook.Events).To(Equal([]string{"pre-install", "pre-upgrade"}))
+			Expect(hook.HasEvent("pre-upgrade")).To(BeTrue())
+			Expect(hook.HasEvent("post-install")).To(BeFalse())
+			Expect(hook.Weight).To(Equal(-5))
+			Expect(hook.HasDeletePolicy("hook-succeeded")).To(BeTrue())
+			Expect(string(hook.Content)).To(ContainSubstring("namespace: namespace"))
+		})
+	})
+
+	It("renders objects", func() {
+		writer := &bytes.Buffer{}
+		err := ObjectRender("namespace", writer, &Options{}, []interface{}{
+			map[string]interface{}{"kind": "Deployment", "metadata": map[string]interface{}{"name": "app"}},
+			map[string]interface{}{"kind": "Namespace", "metadata": map[string]interface{}{"name": "ns"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.String()).To(Equal("---\nmetadata:\n  name: ns\nkind: Namespace\n---\nmetadata:\n  namespace: namespace\n  name: app\nkind: Deployment\n"))
 	})
 })
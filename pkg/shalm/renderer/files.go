@@ -1,9 +1,11 @@
 package renderer
 
 import (
+	"encoding/base64"
 	"io/ioutil"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 // Files -
@@ -11,9 +13,12 @@ type Files struct {
 	Dir string
 }
 
+// FileMap contains the content of files by path. It's returned by Glob
+type FileMap map[string][]byte
+
 // Glob -
-func (f Files) Glob(pattern string) map[string][]byte {
-	result := make(map[string][]byte)
+func (f Files) Glob(pattern string) FileMap {
+	result := make(FileMap)
 	matches, err := filepath.Glob(path.Join(f.Dir, pattern))
 	if err != nil {
 		return result
@@ -32,11 +37,67 @@ func (f Files) Glob(pattern string) map[string][]byte {
 	return result
 }
 
-// Get -
+// Get returns the content of a file or an empty string, if the file doesn't exist
 func (f Files) Get(name string) string {
+	return string(f.GetBytes(name))
+}
+
+// GetBytes returns the content of a file or nil, if the file doesn't exist
+func (f Files) GetBytes(name string) []byte {
 	data, err := ioutil.ReadFile(path.Join(f.Dir, name))
 	if err != nil {
-		return err.Error()
+		return nil
+	}
+	return data
+}
+
+// Lines returns the lines of a file
+func (f Files) Lines(name string) []string {
+	return lines(f.GetBytes(name))
+}
+
+// Get -
+func (f FileMap) Get(name string) string {
+	return string(f[name])
+}
+
+// GetBytes -
+func (f FileMap) GetBytes(name string) []byte {
+	return f[name]
+}
+
+// Lines -
+func (f FileMap) Lines(name string) []string {
+	return lines(f[name])
+}
+
+// AsConfig returns the files as YAML map, which can be used as data of a config map
+func (f FileMap) AsConfig() string {
+	if len(f) == 0 {
+		return ""
+	}
+	m := make(map[string]string)
+	for k, v := range f {
+		m[path.Base(k)] = string(v)
+	}
+	return toYAML(m)
+}
+
+// AsSecrets returns the files base64 encoded as YAML map, which can be used as data of a secret
+func (f FileMap) AsSecrets() string {
+	if len(f) == 0 {
+		return ""
+	}
+	m := make(map[string]string)
+	for k, v := range f {
+		m[path.Base(k)] = base64.StdEncoding.EncodeToString(v)
+	}
+	return toYAML(m)
+}
+
+func lines(data []byte) []string {
+	if data == nil {
+		return []string{}
 	}
-	return string(data)
+	return strings.Split(string(data), "\n")
 }
@@ -19,6 +19,9 @@ var _ = Describe("files", func() {
 		Expect(content).To(HaveKeyWithValue("file2.yaml", []byte("aaaa")))
 
 		Expect(f.Get("file2.yaml")).To(Equal("aaaa"))
-		Expect(f.Get("file3.yaml")).To(ContainSubstring("no such file"))
+		Expect(f.Get("file3.yaml")).To(Equal(""))
+		Expect(f.Lines("file2.yaml")).To(Equal([]string{"aaaa"}))
+		Expect(content.AsConfig()).To(Equal("file1.yaml: \"1234\"\nfile2.yaml: aaaa"))
+		Expect(content.AsSecrets()).To(Equal("file1.yaml: MTIzNA==\nfile2.yaml: YWFhYQ=="))
 	})
 })
@@ -3,48 +3,87 @@ package renderer
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"gopkg.in/yaml.v2"
+	k8syaml "sigs.k8s.io/yaml"
 
+	"github.com/BurntSushi/toml"
 	"github.com/Masterminds/sprig/v3"
 )
 
+// Lookup reads an object from the cluster. It's used to implement the helm function lookup
+type Lookup func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error)
+
+// Template is passed as .Template to helm templates
+type Template struct {
+	Name     string
+	BasePath string
+}
+
 type helmRenderer struct {
-	helpers string
+	dir       string
+	chartName string
+	helpers   map[string]string
+	lookup    Lookup
 }
 
-// HelmFileRenderer -
-func HelmFileRenderer(dir string, value interface{}) (func(filename string, writer io.Writer) error, error) {
-	h, err := newHelmRenderer(dir)
+// HelmFileRenderer renders helm templates. .Template and .Capabilities are added to value, .Values, .Chart, .Release etc. must be provided by the caller
+func HelmFileRenderer(dir string, chartName string, value map[string]interface{}, lookup Lookup) (func(filename string, writer io.Writer) error, error) {
+	h, err := newHelmRenderer(dir, chartName, lookup)
 	if err != nil {
 		return nil, err
 	}
 	return h.fileTemplater(value), nil
 }
-func newHelmRenderer(dir string) (*helmRenderer, error) {
-	h := &helmRenderer{}
-	content, err := ioutil.ReadFile(path.Join(dir, "templates", "_helpers.tpl"))
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
+
+func newHelmRenderer(dir string, chartName string, lookup Lookup) (*helmRenderer, error) {
+	h := &helmRenderer{dir: dir, chartName: chartName, helpers: make(map[string]string), lookup: lookup}
+	templates := path.Join(dir, "templates")
+	err := filepath.Walk(templates, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == templates {
+				return nil
+			}
+			return err
 		}
-		h.helpers = ""
-	} else {
-		h.helpers = string(content)
+		if info.IsDir() || !(strings.HasPrefix(info.Name(), "_") || strings.HasSuffix(info.Name(), ".tpl")) {
+			return nil
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		h.helpers[h.templateName(file)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return h, nil
 }
 
-func (h *helmRenderer) fileTemplater(value interface{}) func(filename string, writer io.Writer) error {
+// templateName returns the name of a template file like helm does (e.g. mariadb/templates/secret.yaml)
+func (h *helmRenderer) templateName(filename string) string {
+	name, err := filepath.Rel(h.dir, filename)
+	if err != nil {
+		name = filepath.Base(filename)
+	}
+	return path.Join(h.chartName, filepath.ToSlash(name))
+}
+
+func (h *helmRenderer) fileTemplater(value map[string]interface{}) func(filename string, writer io.Writer) error {
 	return func(filename string, writer io.Writer) error {
-		tpl, err := h.loadTemplate(filepath.Base(filename))
+		name := h.templateName(filename)
+		tpl, err := h.loadTemplate(name)
 		if err != nil {
 			return err
 		}
@@ -56,26 +95,47 @@ func (h *helmRenderer) fileTemplater(value interface{}) func(filename string, wr
 		if err != nil {
 			return err
 		}
-		return tpl.Execute(writer, value)
+		data := make(map[string]interface{})
+		for k, v := range value {
+			data[k] = v
+		}
+		data["Template"] = Template{Name: name, BasePath: path.Join(h.chartName, "templates")}
+		if _, ok := data["Capabilities"]; !ok {
+			data["Capabilities"] = DefaultCapabilities
+		}
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, data); err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte(removeNoValue(buffer.String())))
+		return err
 	}
-
 }
 
-func notImplemented(_ interface{}) string {
-	panic("not implemented")
+// removeNoValue removes the output of missing values, which helm renders as empty string
+func removeNoValue(s string) string {
+	return strings.Replace(s, "<no value>", "", -1)
 }
 
 func (h *helmRenderer) loadTemplate(name string) (result *template.Template, err error) {
-	result = template.New(name)
-	result = result.Funcs(sprig.TxtFuncMap())
+	funcs := sprig.TxtFuncMap()
+	// Same as helm: templates must not read the environment
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	result = template.New(name).Option("missingkey=zero")
+	result = result.Funcs(funcs)
 	result = result.Funcs(map[string]interface{}{
-		"toToml":   notImplemented,
-		"toYaml":   toYAML,
-		"fromYaml": notImplemented,
-		"toJson":   toJSON,
-		"fromJson": notImplemented,
-		"tpl":      h.tpl(),
-		"required": notImplemented,
+		"toToml":        toTOML,
+		"toYaml":        toYAML,
+		"fromYaml":      fromYAML,
+		"fromYamlArray": fromYAMLArray,
+		"toJson":        toJSON,
+		"fromJson":      fromJSON,
+		"fromJsonArray": fromJSONArray,
+		"tpl":           h.tpl(),
+		"required":      required,
+		"fail":          fail,
+		"lookup":        h.lookupFunc(),
 	})
 	incResult := result
 	result = result.Funcs(map[string]interface{}{
@@ -85,34 +145,65 @@ func (h *helmRenderer) loadTemplate(name string) (result *template.Template, err
 			return buf.String(), err
 		},
 	})
-	if h.helpers != "" {
-		result, err = result.Parse(h.helpers)
-		if err != nil {
+	names := make([]string, 0, len(h.helpers))
+	for helper := range h.helpers {
+		names = append(names, helper)
+	}
+	sort.Strings(names)
+	for _, helper := range names {
+		if helper == name {
+			continue
+		}
+		if _, err = result.New(helper).Parse(h.helpers[helper]); err != nil {
 			return
 		}
 	}
 	return
 }
 
-func (h *helmRenderer) tpl() func(stringTemplate string, values interface{}) interface{} {
-	return func(stringTemplate string, values interface{}) interface{} {
-		tpl, err := h.loadTemplate("internal template")
+func (h *helmRenderer) tpl() func(stringTemplate string, values interface{}) (string, error) {
+	return func(stringTemplate string, values interface{}) (string, error) {
+		tpl, err := h.loadTemplate("tpl")
 		if err != nil {
-			return err.Error()
+			return "", err
 		}
 		tpl, err = tpl.Parse(stringTemplate)
 		if err != nil {
-			return err.Error()
+			return "", err
 		}
 		var buffer bytes.Buffer
 		err = tpl.Execute(&buffer, values)
 		if err != nil {
-			return err.Error()
+			return "", err
 		}
-		return buffer.String()
+		return removeNoValue(buffer.String()), nil
 	}
 }
 
+func (h *helmRenderer) lookupFunc() func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		// Like helm template, lookup returns an empty dict if there is no cluster
+		if h.lookup == nil {
+			return map[string]interface{}{}, nil
+		}
+		return h.lookup(apiVersion, kind, namespace, name)
+	}
+}
+
+func required(warn string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return value, errors.New(warn)
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return value, errors.New(warn)
+	}
+	return value, nil
+}
+
+func fail(msg string) (string, error) {
+	return "", errors.New(msg)
+}
+
 func toYAML(v interface{}) string {
 	data, err := yaml.Marshal(v)
 	if err != nil {
@@ -128,3 +219,46 @@ func toJSON(v interface{}) string {
 	}
 	return strings.TrimSuffix(string(data), "\n")
 }
+
+// toTOML returns the error message on failure like helm does
+func toTOML(v interface{}) string {
+	var buffer bytes.Buffer
+	if err := toml.NewEncoder(&buffer).Encode(v); err != nil {
+		return err.Error()
+	}
+	return buffer.String()
+}
+
+// fromYAML returns the error message in the key Error on failure like helm does
+func fromYAML(s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := k8syaml.Unmarshal([]byte(s), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+// fromYAMLArray returns the error message as single element on failure like helm does
+func fromYAMLArray(s string) []interface{} {
+	a := []interface{}{}
+	if err := k8syaml.Unmarshal([]byte(s), &a); err != nil {
+		a = []interface{}{err.Error()}
+	}
+	return a
+}
+
+func fromJSON(s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+func fromJSONArray(s string) []interface{} {
+	a := []interface{}{}
+	if err := json.Unmarshal([]byte(s), &a); err != nil {
+		a = []interface{}{err.Error()}
+	}
+	return a
+}
@@ -18,11 +18,9 @@ var _ = Describe("helmRenderer", func() {
 			dir := NewTestDir()
 			defer dir.Remove()
 			dir.WriteFile("test.yaml", []byte("test: {{ .Value }}"), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value string
-			}{
-				Value: "test",
-			})
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": "test",
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("test.yaml"), writer)
@@ -35,11 +33,9 @@ var _ = Describe("helmRenderer", func() {
 			dir := NewTestDir()
 			defer dir.Remove()
 			dir.WriteFile("test.yaml", []byte("test:\n{{ .Value | toYaml | indent 2}}"), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value map[string]string
-			}{
-				Value: map[string]string{"key": "value"},
-			})
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": map[string]string{"key": "value"},
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("test.yaml"), writer)
@@ -52,11 +48,9 @@ var _ = Describe("helmRenderer", func() {
 			dir := NewTestDir()
 			defer dir.Remove()
 			dir.WriteFile("test.yaml", []byte("test: {{ .Value | toJson }}"), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value map[string]string
-			}{
-				Value: map[string]string{"key": "value"},
-			})
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": map[string]string{"key": "value"},
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("test.yaml"), writer)
@@ -67,14 +61,11 @@ var _ = Describe("helmRenderer", func() {
 			var err error
 			dir := NewTestDir()
 			defer dir.Remove()
-			dir.WriteFile("test.yaml", []byte("test: {{ tpl .Template . }}"), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value    string
-				Template string
-			}{
-				Value:    "value",
-				Template: "{{ .Value }}",
-			})
+			dir.WriteFile("test.yaml", []byte("test: {{ tpl .Tpl . }}"), 0644)
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": "value",
+				"Tpl":   "{{ .Value }}",
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("test.yaml"), writer)
@@ -90,11 +81,9 @@ var _ = Describe("helmRenderer", func() {
 			{{- .Value -}}
 			{{- end -}}
 			test: {{ include "xxxx" . }}`), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value string
-			}{
-				Value: "value",
-			})
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": "value",
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("test.yaml"), writer)
@@ -113,16 +102,107 @@ var _ = Describe("helmRenderer", func() {
 {{- printf "%s-%s" "chart" "version" | replace "+" "_" | trunc 63 | trimSuffix "-" -}}
 {{- end -}}
 `), 0644)
-			helmFileRenderer, err := HelmFileRenderer(dir.Root(), struct {
-				Value string
-			}{
-				Value: "test",
-			})
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{
+				"Value": "test",
+			}, nil)
 			Expect(err).ToNot(HaveOccurred())
 			writer := &bytes.Buffer{}
 			err = helmFileRenderer(dir.Join("templates/test.yaml"), writer)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.String()).To(Equal("test: chart-version"))
 		})
+
+		render := func(content string, value map[string]interface{}, lookup Lookup) (string, error) {
+			dir := NewTestDir()
+			defer dir.Remove()
+			dir.MkdirAll("templates", 0755)
+			dir.WriteFile("templates/test.yaml", []byte(content), 0644)
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", value, lookup)
+			if err != nil {
+				return "", err
+			}
+			writer := &bytes.Buffer{}
+			err = helmFileRenderer(dir.Join("templates/test.yaml"), writer)
+			return writer.String(), err
+		}
+
+		It("toToml works correct", func() {
+			out, err := render("{{ .Value | toToml }}", map[string]interface{}{"Value": map[string]interface{}{"key": "value"}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("key = \"value\"\n"))
+		})
+
+		It("fromYaml and fromJson work correct", func() {
+			out, err := render(`{{ (fromYaml "a:\n  b: c").a.b }} {{ (fromJson "{\"a\": 1}").a }} {{ (fromYamlArray "[1, 2]") | len }} {{ (fromJsonArray "[1]") | len }}`, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("c 1 2 1"))
+			out, err = render(`{{ (fromJson "{").Error }}`, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(ContainSubstring("unexpected end of JSON input"))
+		})
+
+		It("required fails with file and line", func() {
+			out, err := render("a: {{ required \"value is required\" .Value }}", map[string]interface{}{"Value": "x"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("a: x"))
+			_, err = render("a: 1\nb: {{ required \"value is required\" .Value }}", map[string]interface{}{}, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("chart/templates/test.yaml:2"))
+			Expect(err.Error()).To(ContainSubstring("value is required"))
+		})
+
+		It("fail returns an error", func() {
+			_, err := render("{{ fail \"not supported\" }}", nil, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not supported"))
+		})
+
+		It("tpl returns errors", func() {
+			_, err := render("{{ tpl \"{{ .x\" . }}", nil, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("chart/templates/test.yaml:1"))
+		})
+
+		It("renders missing values as empty string", func() {
+			out, err := render("a: {{ .Values.missing }}", map[string]interface{}{"Values": map[string]interface{}{}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("a: "))
+		})
+
+		It("provides .Template and .Capabilities", func() {
+			out, err := render(`{{ .Template.Name }} {{ .Template.BasePath }} {{ .Capabilities.KubeVersion.Major }} {{ .Capabilities.APIVersions.Has "apps/v1" }} {{ .Capabilities.APIVersions.Has "apps/v1/Deployment" }} {{ .Capabilities.APIVersions.Has "foo/v1" }}`, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("chart/templates/test.yaml chart/templates 1 true true false"))
+		})
+
+		It("lookup uses the given function", func() {
+			out, err := render(`{{ (lookup "v1" "Secret" "ns" "test").data.key }}`, nil, func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+				Expect(apiVersion).To(Equal("v1"))
+				Expect(kind).To(Equal("Secret"))
+				Expect(namespace).To(Equal("ns"))
+				Expect(name).To(Equal("test"))
+				return map[string]interface{}{"data": map[string]interface{}{"key": "value"}}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("value"))
+			out, err = render(`{{ lookup "v1" "Secret" "ns" "test" | len }}`, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("0"))
+		})
+
+		It("include works across helper files", func() {
+			dir := NewTestDir()
+			defer dir.Remove()
+			dir.MkdirAll("templates/sub", 0755)
+			dir.WriteFile("templates/test.yaml", []byte(`test: {{ include "labels" . }}`), 0644)
+			dir.WriteFile("templates/sub/_labels.tpl", []byte(`{{- define "labels" -}}{{ include "name" . }}{{- end -}}`), 0644)
+			dir.WriteFile("templates/_helpers.tpl", []byte(`{{- define "name" -}}{{ .Value }}{{- end -}}`), 0644)
+			helmFileRenderer, err := HelmFileRenderer(dir.Root(), "chart", map[string]interface{}{"Value": "value"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			writer := &bytes.Buffer{}
+			err = helmFileRenderer(dir.Join("templates/test.yaml"), writer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.String()).To(Equal("test: value"))
+		})
 	})
 })
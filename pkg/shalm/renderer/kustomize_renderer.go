@@ -0,0 +1,508 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// kustomization is the supported subset of kustomization.yaml
+type kustomization struct {
+	Namespace             string                   `json:"namespace,omitempty"`
+	NamePrefix            string                   `json:"namePrefix,omitempty"`
+	NameSuffix            string                   `json:"nameSuffix,omitempty"`
+	CommonLabels          map[string]string        `json:"commonLabels,omitempty"`
+	Resources             []string                 `json:"resources,omitempty"`
+	Bases                 []string                 `json:"bases,omitempty"`
+	PatchesStrategicMerge []string                 `json:"patchesStrategicMerge,omitempty"`
+	PatchesJSON6902       []kustomizePatchJSON6902 `json:"patchesJson6902,omitempty"`
+	Images                []kustomizeImage         `json:"images,omitempty"`
+}
+
+type kustomizePatchJSON6902 struct {
+	Target struct {
+		Group     string `json:"group,omitempty"`
+		Version   string `json:"version,omitempty"`
+		Kind      string `json:"kind,omitempty"`
+		Name      string `json:"name,omitempty"`
+		Namespace string `json:"namespace,omitempty"`
+	} `json:"target"`
+	Path  string `json:"path,omitempty"`
+	Patch string `json:"patch,omitempty"`
+}
+
+type kustomizeImage struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+	NewTag  string `json:"newTag,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// kustomizeResource remembers the original name of an object, which is used to match patches
+type kustomizeResource struct {
+	*unstructured.Unstructured
+	originalName string
+}
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// KustomizeFileRenderer builds the kustomization inside dir. All other files (e.g. resources or kustomizations in sub directories)
+// are ignored, because they are only used by the kustomization.
+// The namespace is used, if the kustomization doesn't define one. A suffix is appended to all names
+func KustomizeFileRenderer(dir string, namespace string, suffix string) func(filename string, writer io.Writer) error {
+	return func(filename string, writer io.Writer) error {
+		if filepath.Clean(filename) != kustomizationFile(dir) {
+			return nil
+		}
+		resources, err := kustomize(dir)
+		if err != nil {
+			return err
+		}
+		if suffix != "" {
+			rename(resources, func(name string) string { return name + "-" + suffix })
+		}
+		for _, r := range resources {
+			if r.GetNamespace() == "" && !clusterScoped(r.GetKind()) {
+				r.SetNamespace(namespace)
+			}
+			content, err := k8syaml.Marshal(r.Object)
+			if err != nil {
+				return err
+			}
+			writer.Write([]byte("---\n"))
+			writer.Write(content)
+		}
+		return nil
+	}
+}
+
+// kustomize builds the kustomization inside dir
+func kustomize(dir string) ([]*kustomizeResource, error) {
+	k, err := readKustomization(dir)
+	if err != nil {
+		return nil, err
+	}
+	var resources []*kustomizeResource
+	for _, resource := range append(k.Bases, k.Resources...) {
+		if strings.Contains(resource, "://") || strings.HasPrefix(resource, "github.com/") {
+			return nil, fmt.Errorf("Remote resource %s in kustomization %s is not supported", resource, dir)
+		}
+		file := filepath.Join(dir, resource)
+		stat, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		var loaded []*kustomizeResource
+		if stat.IsDir() {
+			loaded, err = kustomize(file)
+		} else {
+			loaded, err = readResources(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, loaded...)
+	}
+	for _, patch := range k.PatchesStrategicMerge {
+		if resources, err = patchStrategicMerge(resources, filepath.Join(dir, patch)); err != nil {
+			return nil, err
+		}
+	}
+	for _, patch := range k.PatchesJSON6902 {
+		if err = patchJSON6902(resources, dir, &patch); err != nil {
+			return nil, err
+		}
+	}
+	if k.Namespace != "" {
+		for _, r := range resources {
+			if !clusterScoped(r.GetKind()) {
+				r.SetNamespace(k.Namespace)
+			}
+		}
+	}
+	if k.NamePrefix != "" || k.NameSuffix != "" {
+		rename(resources, func(name string) string { return k.NamePrefix + name + k.NameSuffix })
+	}
+	if len(k.CommonLabels) > 0 {
+		for _, r := range resources {
+			if err := addCommonLabels(r.Unstructured, k.CommonLabels); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, r := range resources {
+		setImages(r.Object, k.Images)
+	}
+	return resources, nil
+}
+
+// kustomizationFile returns the kustomization file inside dir, which is used by kustomize
+func kustomizationFile(dir string) string {
+	for _, name := range kustomizationFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+func readKustomization(dir string) (*kustomization, error) {
+	for _, name := range kustomizationFiles {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var k kustomization
+		if err := k8syaml.Unmarshal(content, &k); err != nil {
+			return nil, fmt.Errorf("Invalid kustomization in %s: %s", dir, err.Error())
+		}
+		return &k, nil
+	}
+	return nil, fmt.Errorf("No kustomization found in %s", dir)
+}
+
+// readObjects reads all documents of a yaml file
+func readObjects(file string) ([]map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]interface{}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("Invalid yaml in %s: %s", file, err.Error())
+		}
+		if len(obj) > 0 {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func readResources(file string) ([]*kustomizeResource, error) {
+	objs, err := readObjects(file)
+	if err != nil {
+		return nil, err
+	}
+	var result []*kustomizeResource
+	for _, obj := range objs {
+		u := &unstructured.Unstructured{Object: obj}
+		result = append(result, &kustomizeResource{Unstructured: u, originalName: u.GetName()})
+	}
+	return result, nil
+}
+
+func (r *kustomizeResource) matches(kind string, name string) bool {
+	return r.GetKind() == kind && (r.GetName() == name || r.originalName == name)
+}
+
+// patchStrategicMerge applies all patches inside file. Objects of unknown kinds are patched using a json merge patch
+func patchStrategicMerge(resources []*kustomizeResource, file string) ([]*kustomizeResource, error) {
+	patches, err := readObjects(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		p := unstructured.Unstructured{Object: patch}
+		index := -1
+		for i, r := range resources {
+			if r.matches(p.GetKind(), p.GetName()) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("No target found for patch %s %s in %s", p.GetKind(), p.GetName(), file)
+		}
+		r := resources[index]
+		if directive, _ := patch["$patch"].(string); directive == "delete" {
+			resources = append(resources[:index], resources[index+1:]...)
+			continue
+		}
+		if versioned, err := scheme.Scheme.New(r.GroupVersionKind()); err == nil {
+			merged, err := strategicpatch.StrategicMergeMapPatch(r.Object, patch, versioned)
+			if err != nil {
+				return nil, fmt.Errorf("Error applying patch %s: %s", file, err.Error())
+			}
+			r.Object = merged
+		} else {
+			if err := mergeJSON(r.Unstructured, patch); err != nil {
+				return nil, fmt.Errorf("Error applying patch %s: %s", file, err.Error())
+			}
+		}
+	}
+	return resources, nil
+}
+
+func mergeJSON(obj *unstructured.Unstructured, patch map[string]interface{}) error {
+	original, err := json.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	merged, err := jsonpatch.MergePatch(original, p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(merged, &obj.Object)
+}
+
+// patchJSON6902 applies a json patch to the target of patch
+func patchJSON6902(resources []*kustomizeResource, dir string, patch *kustomizePatchJSON6902) error {
+	content := []byte(patch.Patch)
+	if patch.Path != "" {
+		var err error
+		if content, err = ioutil.ReadFile(filepath.Join(dir, patch.Path)); err != nil {
+			return err
+		}
+	}
+	content, err := k8syaml.YAMLToJSON(content)
+	if err != nil {
+		return fmt.Errorf("Invalid json patch %s: %s", patch.Path, err.Error())
+	}
+	ops, err := jsonpatch.DecodePatch(content)
+	if err != nil {
+		return fmt.Errorf("Invalid json patch %s: %s", patch.Path, err.Error())
+	}
+	target := patch.Target
+	for _, r := range resources {
+		gvk := r.GroupVersionKind()
+		if !r.matches(target.Kind, target.Name) || (target.Group != "" && target.Group != gvk.Group) ||
+			(target.Version != "" && target.Version != gvk.Version) || (target.Namespace != "" && target.Namespace != r.GetNamespace()) {
+			continue
+		}
+		original, err := json.Marshal(r.Object)
+		if err != nil {
+			return err
+		}
+		patched, err := ops.Apply(original)
+		if err != nil {
+			return fmt.Errorf("Error applying json patch to %s %s: %s", target.Kind, target.Name, err.Error())
+		}
+		return json.Unmarshal(patched, &r.Object)
+	}
+	return fmt.Errorf("No target found for json patch %s %s", target.Kind, target.Name)
+}
+
+// rename changes the names of all objects except namespaces and custom resource definitions.
+// References to renamed objects are updated
+func rename(resources []*kustomizeResource, name func(name string) string) {
+	renamed := make(map[string]map[string]string)
+	for _, r := range resources {
+		if r.GetKind() == "Namespace" || r.GetKind() == "CustomResourceDefinition" {
+			continue
+		}
+		if renamed[r.GetKind()] == nil {
+			renamed[r.GetKind()] = make(map[string]string)
+		}
+		newName := name(r.GetName())
+		renamed[r.GetKind()][r.GetName()] = newName
+		r.SetName(newName)
+	}
+	for _, r := range resources {
+		nameReferences(r.Object, func(kind string, obj map[string]interface{}, key string) {
+			if old, ok := obj[key].(string); ok {
+				if newName, ok := renamed[kind][old]; ok {
+					obj[key] = newName
+				}
+			}
+		})
+	}
+}
+
+// nameReferences calls f for all fields of obj, which reference other objects by name
+func nameReferences(obj map[string]interface{}, f func(kind string, obj map[string]interface{}, key string)) {
+	for _, spec := range podSpecs(obj) {
+		f("ServiceAccount", spec, "serviceAccountName")
+		for _, secret := range nestedMaps(spec, "imagePullSecrets") {
+			f("Secret", secret, "name")
+		}
+		for _, volume := range nestedMaps(spec, "volumes") {
+			if m, ok := volume["configMap"].(map[string]interface{}); ok {
+				f("ConfigMap", m, "name")
+			}
+			if m, ok := volume["secret"].(map[string]interface{}); ok {
+				f("Secret", m, "secretName")
+			}
+			if m, ok := volume["persistentVolumeClaim"].(map[string]interface{}); ok {
+				f("PersistentVolumeClaim", m, "claimName")
+			}
+		}
+		for _, container := range containers(spec) {
+			for _, envFrom := range nestedMaps(container, "envFrom") {
+				if m, ok := envFrom["configMapRef"].(map[string]interface{}); ok {
+					f("ConfigMap", m, "name")
+				}
+				if m, ok := envFrom["secretRef"].(map[string]interface{}); ok {
+					f("Secret", m, "name")
+				}
+			}
+			for _, env := range nestedMaps(container, "env") {
+				if m, ok, _ := unstructured.NestedMap(env, "valueFrom"); ok {
+					if ref, ok := m["configMapKeyRef"].(map[string]interface{}); ok {
+						f("ConfigMap", ref, "name")
+					}
+					if ref, ok := m["secretKeyRef"].(map[string]interface{}); ok {
+						f("Secret", ref, "name")
+					}
+				}
+			}
+		}
+	}
+	switch obj["kind"] {
+	case "RoleBinding", "ClusterRoleBinding":
+		if roleRef, ok := obj["roleRef"].(map[string]interface{}); ok {
+			if kind, ok := roleRef["kind"].(string); ok {
+				f(kind, roleRef, "name")
+			}
+		}
+		for _, subject := range nestedMaps(obj, "subjects") {
+			f("ServiceAccount", subject, "name")
+		}
+	case "StatefulSet":
+		if spec, ok := obj["spec"].(map[string]interface{}); ok {
+			f("Service", spec, "serviceName")
+		}
+	}
+}
+
+// podSpecs returns the pod specs of pods, workloads and cron jobs
+func podSpecs(obj map[string]interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, path := range [][]string{{"spec"}, {"spec", "template", "spec"}, {"spec", "jobTemplate", "spec", "template", "spec"}} {
+		if len(path) == 1 && obj["kind"] != "Pod" {
+			continue
+		}
+		if spec, ok := nestedMap(obj, path...); ok {
+			result = append(result, spec)
+		}
+	}
+	return result
+}
+
+func containers(spec map[string]interface{}) []map[string]interface{} {
+	return append(nestedMaps(spec, "initContainers"), nestedMaps(spec, "containers")...)
+}
+
+// nestedMap returns the map at path without copying it
+func nestedMap(obj map[string]interface{}, path ...string) (map[string]interface{}, bool) {
+	for _, key := range path {
+		m, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = m
+	}
+	return obj, true
+}
+
+// nestedMaps returns the maps inside the list obj[key]
+func nestedMaps(obj map[string]interface{}, key string) []map[string]interface{} {
+	var result []map[string]interface{}
+	list, _ := obj[key].([]interface{})
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// addCommonLabels adds labels to the object, the pod templates and the selectors like kustomize does
+func addCommonLabels(obj *unstructured.Unstructured, labels map[string]string) error {
+	paths := [][]string{{"metadata", "labels"}}
+	switch obj.GetKind() {
+	case "Service":
+		paths = append(paths, []string{"spec", "selector"})
+	case "ReplicationController":
+		paths = append(paths, []string{"spec", "selector"}, []string{"spec", "template", "metadata", "labels"})
+	case "Deployment", "ReplicaSet", "DaemonSet", "StatefulSet":
+		paths = append(paths, []string{"spec", "selector", "matchLabels"}, []string{"spec", "template", "metadata", "labels"})
+	case "Job":
+		paths = append(paths, []string{"spec", "template", "metadata", "labels"})
+	case "CronJob":
+		paths = append(paths, []string{"spec", "jobTemplate", "metadata", "labels"}, []string{"spec", "jobTemplate", "spec", "template", "metadata", "labels"})
+	}
+	for _, path := range paths {
+		values, _, err := unstructured.NestedStringMap(obj.Object, path...)
+		if err != nil {
+			return err
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		for k, v := range labels {
+			values[k] = v
+		}
+		if err := unstructured.SetNestedStringMap(obj.Object, values, path...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setImages replaces name, tag or digest of container images
+func setImages(obj map[string]interface{}, images []kustomizeImage) {
+	if len(images) == 0 {
+		return
+	}
+	for _, spec := range podSpecs(obj) {
+		for _, container := range containers(spec) {
+			image, ok := container["image"].(string)
+			if !ok {
+				continue
+			}
+			name, tag := splitImage(image)
+			for _, i := range images {
+				if i.Name != name {
+					continue
+				}
+				if i.NewName != "" {
+					name = i.NewName
+				}
+				if i.NewTag != "" {
+					tag = ":" + i.NewTag
+				}
+				if i.Digest != "" {
+					tag = "@" + i.Digest
+				}
+				container["image"] = name + tag
+			}
+		}
+	}
+}
+
+// splitImage splits an image into name and tag or digest including the separator
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i:]
+	}
+	return image, ""
+}
@@ -0,0 +1,148 @@
+package renderer
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("kustomize", func() {
+
+	var dir TestDir
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("base", 0755)
+		dir.WriteFile("base/kustomization.yaml", []byte("resources:\n- deployment.yaml\n- configmap.yaml\n"), 0644)
+		dir.WriteFile("base/configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  key: value\n"), 0644)
+		dir.WriteFile("base/deployment.yaml", []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.16
+        envFrom:
+        - configMapRef:
+            name: config
+`), 0644)
+	})
+
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("builds a kustomization", func() {
+		dir.WriteFile("kustomization.yaml", []byte(`resources:
+- base
+namePrefix: prod-
+commonLabels:
+  app: test
+images:
+- name: nginx
+  newTag: "1.17"
+patchesStrategicMerge:
+- replicas.yaml
+patchesJson6902:
+- target:
+    group: apps
+    version: v1
+    kind: Deployment
+    name: app
+  path: annotation.yaml
+`), 0644)
+		dir.WriteFile("replicas.yaml", []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: 3\n"), 0644)
+		dir.WriteFile("annotation.yaml", []byte("- op: add\n  path: /metadata/annotations\n  value:\n    patched: \"true\"\n"), 0644)
+		writer := &bytes.Buffer{}
+		err := KustomizeFileRenderer(dir.Root(), "namespace", "")(dir.Join("kustomization.yaml"), writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(Equal(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    patched: "true"
+  labels:
+    app: test
+  name: prod-app
+  namespace: namespace
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: test
+  template:
+    metadata:
+      labels:
+        app: test
+    spec:
+      containers:
+      - envFrom:
+        - configMapRef:
+            name: prod-config
+        image: nginx:1.17
+        name: app
+---
+apiVersion: v1
+data:
+  key: value
+kind: ConfigMap
+metadata:
+  labels:
+    app: test
+  name: prod-config
+  namespace: namespace
+`))
+	})
+
+	It("uses namespace and suffix", func() {
+		dir.WriteFile("kustomization.yaml", []byte("namespace: other\nresources:\n- base/configmap.yaml\n"), 0644)
+		writer := &bytes.Buffer{}
+		err := KustomizeFileRenderer(dir.Root(), "namespace", "suffix")(dir.Join("kustomization.yaml"), writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(ContainSubstring("name: config-suffix\n  namespace: other\n"))
+	})
+
+	It("only builds the top level kustomization", func() {
+		writer := &bytes.Buffer{}
+		err := KustomizeFileRenderer(dir.Root(), "namespace", "")(dir.Join("base", "kustomization.yaml"), writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).To(BeEmpty())
+	})
+
+	It("ignores all other files", func() {
+		dir.WriteFile("kustomization.yaml", []byte("resources:\n- cm.yaml\n"), 0644)
+		dir.WriteFile("cm.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n"), 0644)
+		writer := &bytes.Buffer{}
+		err := DirRender("namespace", writer, &Options{Glob: "*.yaml"}, DirSpec{
+			Dir:          dir.Root(),
+			FileRenderer: KustomizeFileRenderer(dir.Root(), "namespace", ""),
+			Glob:         "[Kk]ustomization*",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Count(writer.String(), "kind: ConfigMap")).To(Equal(1))
+	})
+
+	It("deletes objects", func() {
+		dir.WriteFile("kustomization.yaml", []byte("resources:\n- base\npatchesStrategicMerge:\n- delete.yaml\n"), 0644)
+		dir.WriteFile("delete.yaml", []byte("$patch: delete\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"), 0644)
+		writer := &bytes.Buffer{}
+		err := KustomizeFileRenderer(dir.Root(), "namespace", "")(dir.Join("kustomization.yaml"), writer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.String()).NotTo(ContainSubstring("ConfigMap"))
+	})
+
+	It("fails for missing patch targets", func() {
+		dir.WriteFile("kustomization.yaml", []byte("resources:\n- base\npatchesStrategicMerge:\n- replicas.yaml\n"), 0644)
+		dir.WriteFile("replicas.yaml", []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: missing\n"), 0644)
+		err := KustomizeFileRenderer(dir.Root(), "namespace", "")(dir.Join("kustomization.yaml"), &bytes.Buffer{})
+		Expect(err).To(MatchError(ContainSubstring("No target found for patch Deployment missing")))
+	})
+})
@@ -0,0 +1,17 @@
+package renderer
+
+import (
+	"io"
+	"os"
+)
+
+// ManifestFileRenderer copies a file verbatim
+func ManifestFileRenderer(filename string, writer io.Writer) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(writer, f)
+	return err
+}
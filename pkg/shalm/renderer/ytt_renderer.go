@@ -1,72 +1,444 @@
 package renderer
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/k14s/ytt/pkg/orderedmap"
 	"github.com/k14s/ytt/pkg/template"
+	"github.com/k14s/ytt/pkg/template/core"
+	"github.com/k14s/ytt/pkg/texttemplate"
 	"github.com/k14s/ytt/pkg/yamlmeta"
 	"github.com/k14s/ytt/pkg/yamltemplate"
+	"github.com/k14s/ytt/pkg/yttlibrary"
+	yttoverlay "github.com/k14s/ytt/pkg/yttlibrary/overlay"
 	"go.starlark.net/starlark"
 )
 
-// YttFileRenderer -
-func YttFileRenderer(value starlark.Value) func(filename string, writer io.Writer) error {
-	return func(filename string, writer io.Writer) error {
-		return yttRenderFile(value, filename, writer)
-	}
+const (
+	threadYttFileKey = "shalm.ytt_file"
+	threadYttAPIKey  = "shalm.ytt_api"
+)
+
+// YttWorkspace renders all files of a ytt directory. Like in ytt, files ending with .star or .lib.yml are libraries,
+// which can be loaded using load. Documents annotated with @data/values are merged into the values, which are
+// accessible using data.values. Documents annotated with @overlay/match are applied by Overlay
+type YttWorkspace struct {
+	dir       string
+	self      starlark.StringDict
+	load      string
+	values    interface{}
+	templates map[string]*template.CompiledTemplate
+	overlays  []*yamlmeta.Document
+	skip      map[string]bool
 }
 
-func yttRenderFile(value starlark.Value, filename string, writer io.Writer) error {
-	f, err := os.Open(filename)
+// NewYttWorkspace creates a workspace for dir. self and modules are loaded using load("self",...).
+// values override the data values defined in dir
+func NewYttWorkspace(dir string, self starlark.Value, modules starlark.StringDict, values map[string]interface{}) (*YttWorkspace, error) {
+	w := &YttWorkspace{
+		dir:       dir,
+		self:      starlark.StringDict{"self": self},
+		load:      "load(\"self\", \"self\"",
+		templates: make(map[string]*template.CompiledTemplate),
+		skip:      make(map[string]bool),
+	}
+	for _, name := range modules.Keys() {
+		w.self[name] = modules[name]
+		w.load += fmt.Sprintf(", %q", name)
+	}
+	w.load += ")"
+	dataValues, err := w.dataValues()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	return yttRender(value, f, filename, writer)
+	w.values = mergeOrderedMap(dataValues, toOrderedMap(values).(*orderedmap.Map))
+	return w, nil
 }
 
-func yttRender(value starlark.Value, reader io.Reader, associatedName string, writer io.Writer) error {
-	prefix := bytes.NewBuffer([]byte("#@ load(\"self\", \"self\")\n"))
-	content, err := ioutil.ReadAll(io.MultiReader(prefix, reader))
+// FileRenderer renders a yaml or text template. Libraries, data values and overlays are not written
+func (w *YttWorkspace) FileRenderer(filename string, writer io.Writer) error {
+	rel, err := filepath.Rel(w.dir, filename)
 	if err != nil {
 		return err
 	}
-	docSet, err := yamlmeta.NewDocumentSetFromBytes(content, yamlmeta.DocSetOpts{AssociatedName: associatedName})
-	if err != nil {
+	if w.skip[rel] || isYttLibrary(rel) {
+		return nil
+	}
+	switch {
+	case isYttYaml(rel):
+		_, docSet, err := w.evalYaml(rel, w.values)
+		if err != nil {
+			return err
+		}
+		var items []*yamlmeta.Document
+		for _, doc := range docSet.Items {
+			if template.NewAnnotations(doc).Has(yttoverlay.AnnotationMatch) {
+				w.overlays = append(w.overlays, doc)
+			} else if !doc.IsEmpty() {
+				items = append(items, doc)
+			}
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		body, err := (&yamlmeta.DocumentSet{Items: items}).AsBytes()
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(body)
+		return err
+	case isYttText(rel):
+		_, root, err := w.evalText(rel)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte(root.AsString()))
 		return err
 	}
-	compiledTemplate, err := yamltemplate.NewTemplate(associatedName, yamltemplate.TemplateOpts{}).Compile(docSet)
+	return nil
+}
+
+// Overlay applies all overlays collected by FileRenderer to content
+func (w *YttWorkspace) Overlay(content []byte) ([]byte, error) {
+	if len(w.overlays) == 0 {
+		return content, nil
+	}
+	docSet, err := yamlmeta.NewDocumentSetFromBytes(content, yamlmeta.DocSetOpts{AssociatedName: "rendered", WithoutMeta: true})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, overlay := range w.overlays {
+		op := yttoverlay.OverlayOp{
+			Left:   docSet,
+			Right:  &yamlmeta.DocumentSet{Items: []*yamlmeta.Document{overlay}},
+			Thread: &starlark.Thread{Name: "overlay"},
+		}
+		result, err := op.Apply()
+		if err != nil {
+			return nil, err
+		}
+		docSet = result.(*yamlmeta.DocumentSet)
 	}
+	return docSet.AsBytes()
+}
 
-	thread := &starlark.Thread{Name: "test", Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
-		if module == "self" {
-			return starlark.StringDict{
-				"self": value,
-			}, nil
+// dataValues merges all documents annotated with @data/values in file order
+func (w *YttWorkspace) dataValues() (*orderedmap.Map, error) {
+	var files []string
+	err := filepath.Walk(w.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(w.dir, file); err == nil && !info.IsDir() && isYttYaml(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return orderedmap.NewMap(), nil
+		}
+		return nil, err
+	}
+	sort.Strings(files)
+	var values *yamlmeta.Document
+	for _, rel := range files {
+		docSet, err := w.parseYaml(rel)
+		if err != nil {
+			return nil, err
+		}
+		valuesDocs, _, err := yttlibrary.DataValues{DocSet: docSet}.Extract()
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("Unknown module '%s'", module)
-	}}
+		if len(valuesDocs) == 0 {
+			continue
+		}
+		w.skip[rel] = true
+		_, result, err := w.evalYaml(rel, nil)
+		if err != nil {
+			return nil, err
+		}
+		valuesDocs, nonValuesDocs, err := yttlibrary.DataValues{DocSet: result}.Extract()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range nonValuesDocs {
+			if !doc.IsEmpty() {
+				return nil, fmt.Errorf("Expected data values file '%s' to only have data values documents", rel)
+			}
+		}
+		for _, doc := range valuesDocs {
+			if values == nil {
+				values = doc
+				continue
+			}
+			op := yttoverlay.OverlayOp{
+				Left:       &yamlmeta.DocumentSet{Items: []*yamlmeta.Document{values}},
+				Right:      &yamlmeta.DocumentSet{Items: []*yamlmeta.Document{doc}},
+				Thread:     &starlark.Thread{Name: "data-values"},
+				ExactMatch: true,
+			}
+			result, err := op.Apply()
+			if err != nil {
+				return nil, err
+			}
+			values = result.(*yamlmeta.DocumentSet).Items[0]
+		}
+	}
+	if values == nil {
+		return orderedmap.NewMap(), nil
+	}
+	result, ok := values.AsInterface().(*orderedmap.Map)
+	if !ok {
+		return nil, fmt.Errorf("Expected data values to be a map")
+	}
+	return result, nil
+}
 
-	_, newVal, err := compiledTemplate.Eval(thread, template.NoopCompiledTemplateLoader{})
+func (w *YttWorkspace) read(rel string, prefix string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(w.dir, rel))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return append([]byte(prefix+"\n"), content...), nil
+}
 
-	typedNewVal, ok := newVal.(interface{ AsBytes() ([]byte, error) })
+func (w *YttWorkspace) parseYaml(rel string) (*yamlmeta.DocumentSet, error) {
+	content, err := w.read(rel, "#@ "+w.load)
+	if err != nil {
+		return nil, err
+	}
+	return yamlmeta.NewDocumentSetFromBytes(content, yamlmeta.DocSetOpts{AssociatedName: rel})
+}
+
+func (w *YttWorkspace) evalYaml(rel string, values interface{}) (starlark.StringDict, *yamlmeta.DocumentSet, error) {
+	docSet, err := w.parseYaml(rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	compiledTemplate, err := yamltemplate.NewTemplate(rel, yamltemplate.TemplateOpts{}).Compile(docSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	globals, result, err := w.eval(rel, compiledTemplate, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return globals, result.(*yamlmeta.DocumentSet), nil
+}
+
+func (w *YttWorkspace) evalText(rel string) (starlark.StringDict, *texttemplate.NodeRoot, error) {
+	content, err := w.read(rel, "(@ "+w.load+" -@)")
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := texttemplate.NewParser().Parse(content, rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	trimCode(root)
+	compiledTemplate, err := texttemplate.NewTemplate(rel).Compile(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	globals, result, err := w.eval(rel, compiledTemplate, w.values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return globals, result.(*texttemplate.NodeRoot), nil
+}
+
+func (w *YttWorkspace) evalStarlark(rel string) (starlark.StringDict, error) {
+	content, err := w.read(rel, w.load)
+	if err != nil {
+		return nil, err
+	}
+	instructions := template.NewInstructionSet()
+	compiledTemplate := template.NewCompiledTemplate(rel, template.NewCodeFromBytes(content, instructions),
+		instructions, template.NewNodes(), template.EvaluationCtxDialects{})
+	globals, _, err := w.eval(rel, compiledTemplate, w.values)
+	return globals, err
+}
+
+func (w *YttWorkspace) eval(rel string, compiledTemplate *template.CompiledTemplate, values interface{}) (starlark.StringDict, interface{}, error) {
+	w.templates[rel] = compiledTemplate
+	thread := &starlark.Thread{Name: "ytt=" + rel, Load: w.Load}
+	thread.SetLocal(threadYttFileKey, rel)
+	thread.SetLocal(threadYttAPIKey, yttlibrary.NewAPI(compiledTemplate.TplReplaceNode, values, w))
+	return compiledTemplate.Eval(thread, w)
+}
+
+// resolve returns the path of file relative to the ytt directory. Paths are relative to the loading file
+func (w *YttWorkspace) resolve(thread *starlark.Thread, file string) (string, error) {
+	current, _ := thread.Local(threadYttFileKey).(string)
+	rel := path.Clean(path.Join(path.Dir(current), file))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("File '%s' is outside of ytt directory", file)
+	}
+	return rel, nil
+}
+
+// FindCompiledTemplate implements template.CompiledTemplateLoader
+func (w *YttWorkspace) FindCompiledTemplate(rel string) (*template.CompiledTemplate, error) {
+	compiledTemplate, ok := w.templates[rel]
 	if !ok {
-		return fmt.Errorf("Invalid return type of CompiledTemplate.Eval")
+		return nil, fmt.Errorf("Expected to find '%s' compiled template", rel)
 	}
+	return compiledTemplate, nil
+}
 
-	body, err := typedNewVal.AsBytes()
+// Load implements template.CompiledTemplateLoader
+func (w *YttWorkspace) Load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	if module == "self" {
+		return w.self, nil
+	}
+	if api, ok := thread.Local(threadYttAPIKey).(yttlibrary.API); ok {
+		if m, ok := api[module]; ok {
+			return m, nil
+		}
+	}
+	rel, err := w.resolve(thread, module)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	writer.Write(body)
-	return nil
+	switch {
+	case strings.HasSuffix(rel, ".star"):
+		return w.evalStarlark(rel)
+	case !isYttLibrary(rel):
+		return nil, fmt.Errorf("File '%s' is not a library file", rel)
+	case isYttYaml(rel):
+		globals, _, err := w.evalYaml(rel, w.values)
+		return globals, err
+	case isYttText(rel):
+		globals, _, err := w.evalText(rel)
+		return globals, err
+	}
+	return nil, fmt.Errorf("Unknown module '%s'", module)
 }
+
+// LoadData implements template.CompiledTemplateLoader
+func (w *YttWorkspace) LoadData(thread *starlark.Thread, f *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if args.Len() != 1 {
+		return starlark.None, fmt.Errorf("expected exactly one argument")
+	}
+	file, err := core.NewStarlarkValue(args.Index(0)).AsString()
+	if err != nil {
+		return starlark.None, err
+	}
+	rel, err := w.resolve(thread, file)
+	if err != nil {
+		return starlark.None, err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(w.dir, rel))
+	if err != nil {
+		return starlark.None, err
+	}
+	return starlark.String(content), nil
+}
+
+// ListData implements template.CompiledTemplateLoader
+func (w *YttWorkspace) ListData(thread *starlark.Thread, f *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if args.Len() != 0 {
+		return starlark.None, fmt.Errorf("expected exactly zero arguments")
+	}
+	var result []starlark.Value
+	err := filepath.Walk(w.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(w.dir, file)
+			if err != nil {
+				return err
+			}
+			result = append(result, starlark.String(filepath.ToSlash(rel)))
+		}
+		return nil
+	})
+	if err != nil {
+		return starlark.None, err
+	}
+	return starlark.NewList(result), nil
+}
+
+// trimCode removes leading spaces of statements like (@ load(...) @), which are rejected by starlark as indent
+func trimCode(root *texttemplate.NodeRoot) {
+	for _, item := range root.Items {
+		if code, ok := item.(*texttemplate.NodeCode); ok {
+			prefix := ""
+			content := code.Content
+			if strings.HasPrefix(content, "-") && !strings.HasPrefix(content, "-=") {
+				prefix = "-"
+				content = content[1:]
+			}
+			if !strings.HasPrefix(content, "=") {
+				code.Content = prefix + strings.TrimLeft(content, " \t")
+			}
+		}
+	}
+}
+
+func isYttLibrary(file string) bool {
+	base := path.Base(file)
+	return strings.HasSuffix(base, ".star") || strings.Contains(base, ".lib.")
+}
+
+func isYttYaml(file string) bool {
+	ext := path.Ext(file)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+func isYttText(file string) bool {
+	return path.Ext(file) == ".txt"
+}
+
+// toOrderedMap converts maps into ordered maps with sorted keys, which is required by ytt
+func toOrderedMap(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := orderedmap.NewMap()
+		for _, k := range keys {
+			result.Set(k, toOrderedMap(value[k]))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			result[i] = toOrderedMap(v)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// mergeOrderedMap merges override into values recursively
+func mergeOrderedMap(values *orderedmap.Map, override *orderedmap.Map) *orderedmap.Map {
+	override.Iterate(func(k, v interface{}) {
+		if m, ok := v.(*orderedmap.Map); ok {
+			if existing, ok := values.Get(k); ok {
+				if existing, ok := existing.(*orderedmap.Map); ok {
+					values.Set(k, mergeOrderedMap(existing, m))
+					return
+				}
+			}
+		}
+		values.Set(k, v)
+	})
+	return values
+}
+
+var _ template.CompiledTemplateLoader = &YttWorkspace{}
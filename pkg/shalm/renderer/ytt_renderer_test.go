@@ -11,22 +11,89 @@ import (
 
 var _ = Describe("ytt", func() {
 
+	var dir TestDir
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+	})
+
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	render := func(file string, values map[string]interface{}, modules starlark.StringDict) (string, error) {
+		ytt, err := NewYttWorkspace(dir.Root(), starlark.String("hello"), modules, values)
+		if err != nil {
+			return "", err
+		}
+		out := &bytes.Buffer{}
+		err = ytt.FileRenderer(dir.Join(file), out)
+		return out.String(), err
+	}
+
 	It("template file is working", func() {
-		dir := NewTestDir()
-		defer dir.Remove()
 		dir.WriteFile("ytt.yaml", []byte("test: #@ self\n"), 0644)
-		out := &bytes.Buffer{}
-		renderer := YttFileRenderer(starlark.String("hello"))
-		err := renderer(dir.Join("ytt.yaml"), out)
+		out, err := render("ytt.yaml", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("test: hello\n"))
+	})
+
+	It("loads modules", func() {
+		dir.WriteFile("ytt.yaml", []byte("test: #@ upper(self)\n"), 0644)
+		upper := starlark.NewBuiltin("upper", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.String("HELLO"), nil
+		})
+		out, err := render("ytt.yaml", nil, starlark.StringDict{"upper": upper})
 		Expect(err).NotTo(HaveOccurred())
-		Expect(string(out.Bytes())).To(Equal("test: hello\n"))
+		Expect(out).To(Equal("test: HELLO\n"))
 	})
 
-	It("template is working", func() {
-		in := bytes.NewBuffer([]byte("test: #@ self\n"))
+	It("provides data values", func() {
+		dir.WriteFile("values.yaml", []byte("#@data/values\n---\nname: default\nport: 80\n"), 0644)
+		dir.WriteFile("ytt.yaml", []byte("#@ load(\"@ytt:data\", \"data\")\n---\nname: #@ data.values.name\nport: #@ data.values.port\n"), 0644)
+		out, err := render("ytt.yaml", map[string]interface{}{"name": "chart"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("name: chart\nport: 80\n"))
+		out, err = render("values.yaml", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(BeEmpty())
+	})
+
+	It("loads star and lib files", func() {
+		dir.MkdirAll("lib", 0755)
+		dir.WriteFile("lib/helpers.star", []byte("def name():\n  return self + \"-star\"\n"), 0644)
+		dir.WriteFile("lib/labels.lib.yml", []byte("#@ labels = {\"app\": self}\n---\n"), 0644)
+		dir.WriteFile("ytt.yaml", []byte("#@ load(\"lib/helpers.star\", \"name\")\n#@ load(\"lib/labels.lib.yml\", \"labels\")\n---\nname: #@ name()\nlabels: #@ labels\n"), 0644)
+		out, err := render("ytt.yaml", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("name: hello-star\nlabels:\n  app: hello\n"))
+		out, err = render("lib/helpers.star", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(BeEmpty())
+	})
+
+	It("renders text templates", func() {
+		dir.WriteFile("ytt.txt", []byte("test: (@= self @)\n"), 0644)
+		out, err := render("ytt.txt", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("test: hello\n"))
+	})
+
+	It("applies overlays", func() {
+		dir.WriteFile("overlay.yaml", []byte(`#@ load("@ytt:overlay", "overlay")
+#@overlay/match by=overlay.subset({"kind": "ConfigMap"})
+---
+data:
+  #@overlay/match missing_ok=True
+  added: #@ self
+`), 0644)
+		ytt, err := NewYttWorkspace(dir.Root(), starlark.String("hello"), nil, nil)
+		Expect(err).NotTo(HaveOccurred())
 		out := &bytes.Buffer{}
-		err := yttRender(starlark.String("hello"), in, "stdin", out)
+		Expect(ytt.FileRenderer(dir.Join("overlay.yaml"), out)).NotTo(HaveOccurred())
+		Expect(out.String()).To(BeEmpty())
+		content, err := ytt.Overlay([]byte("kind: ConfigMap\ndata:\n  key: value\n---\nkind: Secret\n"))
 		Expect(err).NotTo(HaveOccurred())
-		Expect(string(out.Bytes())).To(Equal("test: hello\n"))
+		Expect(string(content)).To(Equal("kind: ConfigMap\ndata:\n  key: value\n  added: hello\n---\nkind: Secret\n"))
 	})
 })
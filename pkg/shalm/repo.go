@@ -3,13 +3,17 @@ package shalm
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	shalmv1a1 "github.com/kramerul/shalm/api/v1alpha1"
@@ -19,6 +23,9 @@ import (
 type repoImpl struct {
 	cacheDir   string
 	httpClient *http.Client
+	mutex      sync.Mutex
+	// modules maps the url of a chart to the directory, which is used to load its modules
+	modules map[string]string
 }
 
 var _ Repo = &repoImpl{}
@@ -59,24 +66,203 @@ func (r *repoImpl) Get(thread *starlark.Thread, url string, opts ...ChartOption)
 		}
 	}
 
+	dir, err := r.Directory(url)
+	if err != nil {
+		return nil, err
+	}
+	return proxyFunc(newChart(thread, r, dir, opts...))
+}
+
+// Directory -
+func (r *repoImpl) Directory(url string) (string, error) {
 	if strings.HasPrefix(url, "https:") || strings.HasPrefix(url, "http:") {
 		res, err := r.httpClient.Get(url)
 		if err != nil {
-			return nil, fmt.Errorf("Error fetching %s: %v", url, err)
+			return "", fmt.Errorf("Error fetching %s: %v", url, err)
 		}
+		defer res.Body.Close()
 		if res.StatusCode != 200 {
-			return nil, fmt.Errorf("Error fetching %s: status=%d", url, res.StatusCode)
+			return "", fmt.Errorf("Error fetching %s: status=%d", url, res.StatusCode)
 		}
-		defer res.Body.Close()
-		return proxyFunc(newChartFromReader(thread, r, r.cacheDirForChart([]byte(url)), res.Body, opts...))
+		dir := r.cacheDirForChart([]byte(url))
+		return dir, tarExtract(res.Body, dir)
 	}
 	if stat, err := os.Stat(url); err == nil {
 		if stat.IsDir() {
-			return proxyFunc(newChart(thread, r, url, opts...))
+			return url, nil
+		}
+		in, err := os.Open(url)
+		if err != nil {
+			return "", err
+		}
+		defer in.Close()
+		dir := r.cacheDirForChart([]byte(url))
+		return dir, tarExtract(in, dir)
+	}
+	return "", fmt.Errorf("Chart not found for url %s", url)
+}
+
+// Module returns the local directory of a chart, whose modules are loaded using load("@url//file").
+// Each url is fetched only once per process. Archives are extracted into a cache directory per url and digest,
+// which is never removed, because modules inside it may still be evaluated
+func (r *repoImpl) Module(url string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if dir, ok := r.modules[url]; ok {
+		return dir, nil
+	}
+	dir, err := r.module(url)
+	if err != nil {
+		return "", err
+	}
+	if r.modules == nil {
+		r.modules = make(map[string]string)
+	}
+	r.modules[url] = dir
+	return dir, nil
+}
+
+func (r *repoImpl) module(url string) (string, error) {
+	var content []byte
+	if strings.HasPrefix(url, "https:") || strings.HasPrefix(url, "http:") {
+		res, err := r.httpClient.Get(url)
+		if err != nil {
+			return "", fmt.Errorf("Error fetching %s: %v", url, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return "", fmt.Errorf("Error fetching %s: status=%d", url, res.StatusCode)
+		}
+		if content, err = ioutil.ReadAll(res.Body); err != nil {
+			return "", fmt.Errorf("Error fetching %s: %v", url, err)
+		}
+	} else {
+		stat, err := os.Stat(url)
+		if err != nil {
+			return "", fmt.Errorf("Chart not found for url %s", url)
+		}
+		if stat.IsDir() {
+			return url, nil
+		}
+		if content, err = ioutil.ReadFile(url); err != nil {
+			return "", err
+		}
+	}
+	urlSum := md5.Sum([]byte(url))
+	digest := sha256.Sum256(content)
+	dir := path.Join(r.cacheDir, "modules", hex.EncodeToString(urlSum[:]), hex.EncodeToString(digest[:]))
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	if err := os.MkdirAll(path.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	// Extract into a temporary directory first, so that the cache never contains partial archives
+	tmp, err := ioutil.TempDir(path.Dir(dir), "extract")
+	if err != nil {
+		return "", err
+	}
+	if err := tarExtract(bytes.NewReader(content), tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		// Another process extracted the same archive in the meantime
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return dir, nil
 		}
-		return proxyFunc(newChartFromFile(thread, r, r.cacheDirForChart([]byte(url)), url, opts...))
+		return "", err
+	}
+	return dir, nil
+}
+
+// File returns the local path of a file. Files with http(s) urls are downloaded into the cache.
+// A cached file is revalidated on each call using ETag and Last-Modified and downloaded again, if it has changed
+func (r *repoImpl) File(url string) (string, error) {
+	if strings.HasPrefix(url, "https:") || strings.HasPrefix(url, "http:") {
+		md5Sum := md5.Sum([]byte(url))
+		dir := path.Join(r.cacheDir, hex.EncodeToString(md5Sum[:]))
+		name := path.Base(strings.SplitN(url, "?", 2)[0])
+		if name == "" || name == "/" || name == "." {
+			name = "manifest.yaml"
+		}
+		file := path.Join(dir, name)
+		validatorsFile := path.Join(dir, ".validators")
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(file); err == nil {
+			if content, err := ioutil.ReadFile(validatorsFile); err == nil {
+				var validators cacheValidators
+				if json.Unmarshal(content, &validators) == nil {
+					validators.setHeaders(req)
+				}
+			}
+		}
+		res, err := r.httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("Error fetching %s: %v", url, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusNotModified {
+			return file, nil
+		}
+		if res.StatusCode != 200 {
+			return "", fmt.Errorf("Error fetching %s: status=%d", url, res.StatusCode)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		os.Remove(validatorsFile)
+		// Download into a temporary file first, so that the cache never contains partial downloads
+		out, err := ioutil.TempFile(dir, name)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(out.Name())
+		_, err = io.Copy(out, res.Body)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.Rename(out.Name(), file); err != nil {
+			return "", err
+		}
+		validators := cacheValidators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+		if validators.ETag != "" || validators.LastModified != "" {
+			content, err := json.Marshal(validators)
+			if err != nil {
+				return "", err
+			}
+			if err := ioutil.WriteFile(validatorsFile, content, 0644); err != nil {
+				return "", err
+			}
+		}
+		return file, nil
+	}
+	if _, err := os.Stat(url); err != nil {
+		return "", fmt.Errorf("File not found for url %s", url)
+	}
+	return url, nil
+}
+
+// cacheValidators are the headers of a download, which are used to revalidate the cached file
+type cacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func (v cacheValidators) setHeaders(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
 	}
-	return nil, fmt.Errorf("Chart not found for url %s", url)
 }
 
 func (r *repoImpl) cacheDirForChart(data []byte) string {
@@ -89,11 +275,10 @@ func (r *repoImpl) cacheDirForChart(data []byte) string {
 func (r *repoImpl) GetFromSpec(thread *starlark.Thread, spec *shalmv1a1.ChartSpec) (ChartValue, error) {
 	c, err := newChartFromReader(thread, r, r.cacheDirForChart(spec.ChartTgz), bytes.NewReader(spec.ChartTgz),
 		WithNamespace(spec.Namespace), WithSuffix(spec.Suffix), WithArgs(toStarlark(spec.Args).(starlark.Tuple)),
-		WithKwArgs(kwargsToStarlark(spec.KwArgs)))
+		WithKwArgs(kwargsToStarlark(spec.KwArgs)), WithAtomic(spec.Atomic), WithProfile(spec.Profile), WithValues(spec.Values))
 	if err != nil {
 		return nil, err
 	}
-	c.mergeValues(spec.Values)
 	return c, nil
 }
 
@@ -103,12 +288,3 @@ func newChartFromReader(thread *starlark.Thread, repo Repo, dir string, reader i
 	}
 	return newChart(thread, repo, dir, opts...)
 }
-
-func newChartFromFile(thread *starlark.Thread, repo Repo, dir string, tarFile string, opts ...ChartOption) (*chartImpl, error) {
-	in, err := os.Open(tarFile)
-	if err != nil {
-		return nil, err
-	}
-	defer in.Close()
-	return newChartFromReader(thread, repo, dir, in, opts...)
-}
@@ -4,11 +4,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"path"
 	"path/filepath"
 	"runtime"
 
 	shalmv1a1 "github.com/kramerul/shalm/api/v1alpha1"
+	. "github.com/kramerul/shalm/pkg/shalm/test"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"go.starlark.net/starlark"
@@ -76,6 +78,41 @@ var _ = Describe("OCIRepo", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(chart.GetName()).To(Equal("mariadb"))
 		})
-
+		It("revalidates downloaded files", func() {
+			version := "v1"
+			downloads := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/release.yaml" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Header.Get("If-None-Match") == version {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				downloads++
+				w.Header().Set("ETag", version)
+				w.Write([]byte("version: " + version + "\n"))
+			}))
+			defer server.Close()
+			dir := NewTestDir()
+			defer dir.Remove()
+			repo := &repoImpl{cacheDir: dir.Root(), httpClient: server.Client()}
+			content := func() string {
+				file, err := repo.File(server.URL + "/release.yaml")
+				Expect(err).ToNot(HaveOccurred())
+				data, err := ioutil.ReadFile(file)
+				Expect(err).ToNot(HaveOccurred())
+				return string(data)
+			}
+			Expect(content()).To(Equal("version: v1\n"))
+			Expect(content()).To(Equal("version: v1\n"))
+			Expect(downloads).To(Equal(1))
+			version = "v2"
+			Expect(content()).To(Equal("version: v2\n"))
+			Expect(downloads).To(Equal(2))
+			_, err := repo.File(server.URL + "/missing.yaml")
+			Expect(err).To(MatchError(ContainSubstring("status=404")))
+		})
 	})
 })
@@ -0,0 +1,72 @@
+package shalm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// Rotate replaces the passwords of the given user credentials and applies the chart.
+// If no credentials are given, all user credentials of the chart and its sub charts are rotated.
+// Credentials with a fixed password can't be rotated. They are reported as error, if they are given explicitly
+func Rotate(thread *starlark.Thread, c ChartValue, k K8s, credentials ...string) error {
+	chart, ok := c.(*chartImpl)
+	if !ok {
+		return fmt.Errorf("rotation is not supported for %s", c.GetName())
+	}
+	names := make(map[string]bool)
+	for _, name := range credentials {
+		names[name] = false
+	}
+	all := len(credentials) == 0
+	var fixed []string
+	rotated := chart.markRotation(names, all, &fixed)
+	var missing []string
+	for name, found := range names {
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("user_credential %s not found in chart %s", strings.Join(missing, ", "), c.GetName())
+	}
+	sort.Strings(fixed)
+	if !all && len(fixed) != 0 {
+		return fmt.Errorf("user_credential %s can't be rotated, because the password is given explicitly", strings.Join(fixed, ", "))
+	}
+	if rotated == 0 {
+		return fmt.Errorf("chart %s has no user_credential, which can be rotated", c.GetName())
+	}
+	for _, name := range fixed {
+		fmt.Printf("user_credential %s not rotated, because the password is given explicitly\n", name)
+	}
+	return chart.applyRelease(thread, k, "rotate credentials")
+}
+
+// markRotation marks the user credentials for rotation and returns their number. Credentials with fixed passwords are added to fixed
+func (c *chartImpl) markRotation(names map[string]bool, all bool, fixed *[]string) int {
+	rotated := 0
+	c.eachSubChart(func(subChart *chartImpl) error {
+		rotated += subChart.markRotation(names, all, fixed)
+		return nil
+	})
+	for _, cred := range c.credentials {
+		userCred, ok := cred.(*userCredential)
+		if !ok {
+			continue
+		}
+		if _, found := names[userCred.name]; found || all {
+			names[userCred.name] = true
+			if userCred.fixedPassword {
+				*fixed = append(*fixed, userCred.name)
+				continue
+			}
+			userCred.rotate = true
+			rotated++
+		}
+	}
+	return rotated
+}
@@ -0,0 +1,141 @@
+package shalm
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Rotate", func() {
+	var dir TestDir
+	var k *k8sNativeImpl
+	thread := &starlark.Thread{Name: "main"}
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.MkdirAll("templates", 0755)
+		dir.WriteFile("Chart.yaml", []byte("name: mariadb\nversion: 6.12.2\n"), 0644)
+		dir.WriteFile("Chart.star", []byte(`
+def init(self):
+  self.db = user_credential("db", rotate_after="90d", grace_period="1h")
+  self.admin = user_credential("admin")
+`), 0644)
+		dir.WriteFile("templates/deployment.yaml", []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  template:
+    metadata:
+      annotations:
+        checksum/db: {{ .Values.db.checksum }}
+`), 0644)
+		k = newFakeK8sNative()
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	load := func() ChartValue {
+		c, err := newChart(thread, NewRepo(), dir.Root(), WithNamespace("namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	readSecret := func(name string) *corev1.Secret {
+		writer := &bytes.Buffer{}
+		Expect(k.Get("secret", name, writer, &K8sOptions{Namespaced: true})).NotTo(HaveOccurred())
+		var secret corev1.Secret
+		_, _, err := serializer.Decode(writer.Bytes(), nil, &secret)
+		Expect(err).NotTo(HaveOccurred())
+		return &secret
+	}
+
+	deploymentChecksum := func() string {
+		writer := &bytes.Buffer{}
+		Expect(k.Get("deployment", "test", writer, &K8sOptions{Namespaced: true})).NotTo(HaveOccurred())
+		return writer.String()
+	}
+
+	It("keeps passwords without rotation", func() {
+		Expect(load().Apply(thread, k)).NotTo(HaveOccurred())
+		before := readSecret("db")
+		Expect(before.Annotations).To(HaveKey(checksumAnnotation))
+		Expect(before.Annotations).To(HaveKey(rotatedAtAnnotation))
+		Expect(load().Apply(thread, k)).NotTo(HaveOccurred())
+		after := readSecret("db")
+		Expect(after.Data["password"]).To(Equal(before.Data["password"]))
+		Expect(after.Data).NotTo(HaveKey("previous-password"))
+		Expect(after.Annotations[checksumAnnotation]).To(Equal(before.Annotations[checksumAnnotation]))
+	})
+
+	It("rotates a single credential", func() {
+		Expect(load().Apply(thread, k)).NotTo(HaveOccurred())
+		db := readSecret("db")
+		admin := readSecret("admin")
+		deployment := deploymentChecksum()
+		Expect(deployment).To(ContainSubstring(db.Annotations[checksumAnnotation]))
+
+		Expect(Rotate(thread, load(), k, "db")).NotTo(HaveOccurred())
+		rotated := readSecret("db")
+		Expect(rotated.Data["password"]).NotTo(Equal(db.Data["password"]))
+		Expect(rotated.Data["previous-password"]).To(Equal(db.Data["password"]))
+		Expect(rotated.Data["username"]).To(Equal(db.Data["username"]))
+		Expect(rotated.Annotations[checksumAnnotation]).NotTo(Equal(db.Annotations[checksumAnnotation]))
+		Expect(deploymentChecksum()).To(ContainSubstring(rotated.Annotations[checksumAnnotation]))
+		Expect(readSecret("admin").Data["password"]).To(Equal(admin.Data["password"]))
+	})
+
+	It("rotates all credentials", func() {
+		Expect(load().Apply(thread, k)).NotTo(HaveOccurred())
+		admin := readSecret("admin")
+		Expect(Rotate(thread, load(), k)).NotTo(HaveOccurred())
+		Expect(readSecret("admin").Data["password"]).NotTo(Equal(admin.Data["password"]))
+	})
+
+	It("reports credentials with fixed passwords", func() {
+		dir.WriteFile("Chart.star", []byte(`
+def init(self):
+  self.db = user_credential("db")
+  self.admin = user_credential("admin", password="fixed")
+`), 0644)
+		Expect(load().Apply(thread, k)).NotTo(HaveOccurred())
+		Expect(Rotate(thread, load(), k, "admin")).To(MatchError(ContainSubstring("user_credential admin can't be rotated")))
+		db := readSecret("db")
+		Expect(Rotate(thread, load(), k)).NotTo(HaveOccurred())
+		Expect(readSecret("db").Data["password"]).NotTo(Equal(db.Data["password"]))
+		Expect(readSecret("admin").Data["password"]).To(Equal([]byte("fixed")))
+
+		dir.WriteFile("Chart.star", []byte("def init(self):\n  self.admin = user_credential(\"admin\", password=\"fixed\")\n"), 0644)
+		Expect(Rotate(thread, load(), k)).To(MatchError(ContainSubstring("has no user_credential, which can be rotated")))
+	})
+
+	It("fails for unknown credentials", func() {
+		Expect(Rotate(thread, load(), k, "unknown")).To(MatchError(ContainSubstring("user_credential unknown not found")))
+	})
+
+	Context("userCredential", func() {
+		now := time.Now()
+		It("rotates after rotate_after has expired", func() {
+			c := &userCredential{password: "password", rotateAfter: time.Hour, rotatedAt: now.Add(-2 * time.Hour)}
+			Expect(c.needsRotation(now)).To(BeTrue())
+			c.rotatedAt = now.Add(-30 * time.Minute)
+			Expect(c.needsRotation(now)).To(BeFalse())
+		})
+		It("never rotates fixed passwords", func() {
+			c := &userCredential{password: "password", fixedPassword: true, rotate: true}
+			Expect(c.needsRotation(now)).To(BeFalse())
+		})
+		It("parses durations with days", func() {
+			Expect(parseDuration("90d")).To(Equal(90 * 24 * time.Hour))
+			Expect(parseDuration("1h30m")).To(Equal(90 * time.Minute))
+			_, err := parseDuration("xd")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,114 @@
+package shalm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v2"
+)
+
+const schemaFile = "values.schema.json"
+
+// loadSchema reads values.schema.json, if the chart contains one
+func (c *chartImpl) loadSchema() error {
+	data, err := ioutil.ReadFile(c.path(schemaFile))
+	if err != nil {
+		return err
+	}
+	c.schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return fmt.Errorf("Invalid %s in chart %s: %s", schemaFile, c.GetName(), err.Error())
+	}
+	return nil
+}
+
+// validateValues validates the values of the chart against values.schema.json. Sub charts are validated by their own schema
+func (c *chartImpl) validateValues() error {
+	if c.schema == nil {
+		return nil
+	}
+	values := make(map[string]interface{})
+	for k, v := range c.values {
+		if _, ok := v.(*chartImpl); ok {
+			continue
+		}
+		value, err := toGoChecked(v)
+		if err != nil {
+			return at(k, err)
+		}
+		if value != nil {
+			values[k] = value
+		}
+	}
+	result, err := c.schema.Validate(gojsonschema.NewGoLoader(values))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	var messages []string
+	for _, e := range result.Errors() {
+		messages = append(messages, fmt.Sprintf("%s: %s", e.Field(), e.Description()))
+	}
+	sort.Strings(messages)
+	return fmt.Errorf("values of chart %s don't match %s:\n%s", c.GetName(), schemaFile, strings.Join(messages, "\n"))
+}
+
+// Schema writes a JSON schema, which is inferred from values.yaml of the chart in dir
+func Schema(dir string, writer io.Writer) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "values.yaml"))
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	schema := inferSchema(values)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema)
+}
+
+func inferSchema(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{}
+	case string:
+		return map[string]interface{}{"type": "string"}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case int, int64, uint64:
+		return map[string]interface{}{"type": "integer"}
+	case float64:
+		return map[string]interface{}{"type": "number"}
+	case []interface{}:
+		result := map[string]interface{}{"type": "array"}
+		if len(v) > 0 {
+			result["items"] = inferSchema(v[0])
+		}
+		return result
+	case map[string]interface{}:
+		properties := make(map[string]interface{})
+		for key, val := range v {
+			properties[key] = inferSchema(val)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	case map[interface{}]interface{}:
+		properties := make(map[string]interface{})
+		for key, val := range v {
+			properties[fmt.Sprint(key)] = inferSchema(val)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,90 @@
+package shalm
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+
+	. "github.com/kramerul/shalm/pkg/shalm/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("Schema", func() {
+	var dir TestDir
+	thread := &starlark.Thread{Name: "main"}
+	const schema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "replicas": {"type": "integer"},
+    "image": {
+      "type": "object",
+      "properties": {"tag": {"type": "string"}},
+      "additionalProperties": false
+    }
+  }
+}`
+
+	BeforeEach(func() {
+		dir = NewTestDir()
+		dir.WriteFile("values.yaml", []byte("replicas: 1\nimage:\n  tag: v1\n"), 0644)
+		dir.WriteFile("values.schema.json", []byte(schema), 0644)
+	})
+	AfterEach(func() {
+		dir.Remove()
+	})
+
+	It("accepts valid values", func() {
+		_, err := newChart(thread, NewRepo(), dir.Root(), WithValues(map[string]interface{}{"replicas": 2}))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports the path of invalid values", func() {
+		_, err := newChart(thread, NewRepo(), dir.Root(), WithValues(map[string]interface{}{
+			"replicas": "two",
+			"image":    map[string]interface{}{"tga": "v2"},
+		}))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("replicas: Invalid type"))
+		Expect(err.Error()).To(ContainSubstring("image: Additional property tga is not allowed"))
+	})
+
+	It("validates values set during init", func() {
+		dir.WriteFile("Chart.star", []byte("def init(self,replicas=1):\n  self.replicas = replicas\n"), 0644)
+		_, err := newChart(thread, NewRepo(), dir.Root(), WithKwArgs([]starlark.Tuple{{starlark.String("replicas"), starlark.String("3")}}))
+		Expect(err).To(MatchError(ContainSubstring("replicas: Invalid type")))
+	})
+
+	It("validates values of sub charts", func() {
+		dir.MkdirAll("parent", 0755)
+		dir.WriteFile("parent/Chart.star", []byte("def init(self):\n  self.sub = chart('..')\n"), 0644)
+		_, err := newChart(thread, NewRepo(), path.Join(dir.Root(), "parent"), WithValues(map[string]interface{}{
+			"sub": map[string]interface{}{"replicas": "two"},
+		}))
+		Expect(err).To(MatchError(ContainSubstring("replicas: Invalid type")))
+	})
+
+	It("infers a schema from values.yaml", func() {
+		dir.WriteFile("values.yaml", []byte("replicas: 1\nratio: 0.5\nenabled: true\nimage:\n  tag: v1\nports:\n- 80\nempty:\n"), 0644)
+		writer := &bytes.Buffer{}
+		Expect(Schema(dir.Root(), writer)).NotTo(HaveOccurred())
+		var result map[string]interface{}
+		Expect(json.Unmarshal(writer.Bytes(), &result)).NotTo(HaveOccurred())
+		Expect(result).To(Equal(map[string]interface{}{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"type":    "object",
+			"properties": map[string]interface{}{
+				"replicas": map[string]interface{}{"type": "integer"},
+				"ratio":    map[string]interface{}{"type": "number"},
+				"enabled":  map[string]interface{}{"type": "boolean"},
+				"image": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+					"tag": map[string]interface{}{"type": "string"},
+				}},
+				"ports": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}},
+				"empty": map[string]interface{}{},
+			},
+		}))
+	})
+})
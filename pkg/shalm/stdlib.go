@@ -0,0 +1,382 @@
+package shalm
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"regexp"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"gopkg.in/yaml.v2"
+)
+
+// stdlib contains all predeclared modules, which are available inside Chart.star and ytt templates
+func stdlib() starlark.StringDict {
+	return starlark.StringDict{
+		"json": &starlarkstruct.Module{
+			Name: "json",
+			Members: starlark.StringDict{
+				"encode": starlark.NewBuiltin("json.encode", jsonEncode),
+				"decode": starlark.NewBuiltin("json.decode", jsonDecode),
+			},
+		},
+		"yaml": &starlarkstruct.Module{
+			Name: "yaml",
+			Members: starlark.StringDict{
+				"encode": starlark.NewBuiltin("yaml.encode", yamlEncode),
+				"decode": starlark.NewBuiltin("yaml.decode", yamlDecode),
+			},
+		},
+		"base64": &starlarkstruct.Module{
+			Name: "base64",
+			Members: starlark.StringDict{
+				"encode": starlark.NewBuiltin("base64.encode", base64Encode),
+				"decode": starlark.NewBuiltin("base64.decode", base64Decode),
+			},
+		},
+		"hashlib": &starlarkstruct.Module{
+			Name: "hashlib",
+			Members: starlark.StringDict{
+				"md5":    hashBuiltin("hashlib.md5", md5.New),
+				"sha1":   hashBuiltin("hashlib.sha1", sha1.New),
+				"sha256": hashBuiltin("hashlib.sha256", sha256.New),
+			},
+		},
+		"re": &starlarkstruct.Module{
+			Name: "re",
+			Members: starlark.StringDict{
+				"match":    starlark.NewBuiltin("re.match", reMatch),
+				"find_all": starlark.NewBuiltin("re.find_all", reFindAll),
+				"sub":      starlark.NewBuiltin("re.sub", reSub),
+				"split":    starlark.NewBuiltin("re.split", reSplit),
+			},
+		},
+		"time": &starlarkstruct.Module{
+			Name: "time",
+			Members: starlark.StringDict{
+				"now":   starlark.NewBuiltin("time.now", timeNow),
+				"unix":  starlark.NewBuiltin("time.unix", timeUnix),
+				"parse": starlark.NewBuiltin("time.parse", timeParse),
+			},
+		},
+	}
+}
+
+func jsonEncode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &value); err != nil {
+		return nil, err
+	}
+	v, err := toEncodable(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), err.Error())
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(data), nil
+}
+
+// toEncodable converts value like toGo, but keeps None as null. An error is returned for values, which can't be encoded
+func toEncodable(value starlark.Value) (result interface{}, err error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.String:
+		return v.GoString(), nil
+	case starlark.Indexable: // Tuple, List
+		a := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := toEncodable(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, e)
+		}
+		return a, nil
+	case starlark.IterableMapping:
+		d := make(map[string]interface{})
+		for _, t := range v.Items() {
+			key, ok := t.Index(0).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("cannot encode key of type %s", t.Index(0).Type())
+			}
+			e, err := toEncodable(t.Index(1))
+			if err != nil {
+				return nil, err
+			}
+			d[key.GoString()] = e
+		}
+		return d, nil
+	case *starlarkstruct.Struct:
+		members := starlark.StringDict{}
+		v.ToStringDict(members)
+		d := make(map[string]interface{})
+		for k, m := range members {
+			e, err := toEncodable(m)
+			if err != nil {
+				return nil, err
+			}
+			d[k] = e
+		}
+		return d, nil
+	case *starlarkStruct:
+		return toEncodable(v.Struct)
+	}
+	if result, err = toGoChecked(value); err != nil {
+		return nil, fmt.Errorf("cannot encode %s", value.Type())
+	}
+	return result, nil
+}
+
+func jsonDecode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return toStarlark(jsonNumbers(value)), nil
+}
+
+// jsonNumbers replaces all json.Number values by int64 or float64
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+	return value
+}
+
+func yamlEncode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &value); err != nil {
+		return nil, err
+	}
+	v, err := toEncodable(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), err.Error())
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(data), nil
+}
+
+func yamlDecode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(data), &value); err != nil {
+		return nil, err
+	}
+	return toStarlark(value), nil
+}
+
+func base64Encode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	return starlark.String(base64.StdEncoding.EncodeToString([]byte(data))), nil
+}
+
+func base64Decode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(decoded), nil
+}
+
+func hashBuiltin(name string, newHash func() hash.Hash) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var data string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "data", &data); err != nil {
+			return nil, err
+		}
+		h := newHash()
+		h.Write([]byte(data))
+		return starlark.String(hex.EncodeToString(h.Sum(nil))), nil
+	})
+}
+
+func reMatch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "string", &s); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(re.MatchString(s)), nil
+}
+
+func reFindAll(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "string", &s); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var result []starlark.Value
+	for _, match := range re.FindAllString(s, -1) {
+		result = append(result, starlark.String(match))
+	}
+	return starlark.NewList(result), nil
+}
+
+func reSub(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, repl, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "repl", &repl, "string", &s); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(re.ReplaceAllString(s, repl)), nil
+}
+
+func reSplit(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "string", &s); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var result []starlark.Value
+	for _, part := range re.Split(s, -1) {
+		result = append(result, starlark.String(part))
+	}
+	return starlark.NewList(result), nil
+}
+
+func timeNow(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.String(time.Now().UTC().Format(time.RFC3339)), nil
+}
+
+func timeUnix(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(time.Now().Unix()), nil
+}
+
+func timeParse(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &value); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(t.Unix()), nil
+}
+
+// starlarkStruct extends starlarkstruct.Struct by to_json
+type starlarkStruct struct {
+	*starlarkstruct.Struct
+}
+
+var (
+	_ starlark.HasAttrs = (*starlarkStruct)(nil)
+)
+
+func makeStruct(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	s, err := starlarkstruct.Make(thread, fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return &starlarkStruct{s.(*starlarkstruct.Struct)}, nil
+}
+
+// Attr -
+func (s *starlarkStruct) Attr(name string) (starlark.Value, error) {
+	if name == "to_json" {
+		return starlark.NewBuiltin("to_json", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			v, err := toEncodable(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fn.Name(), err.Error())
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return starlark.String(data), nil
+		}), nil
+	}
+	return s.Struct.Attr(name)
+}
+
+// AttrNames -
+func (s *starlarkStruct) AttrNames() []string {
+	return append(s.Struct.AttrNames(), "to_json")
+}
+
+// Binary -
+func (s *starlarkStruct) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	other, ok := y.(*starlarkStruct)
+	if !ok {
+		return nil, nil
+	}
+	result, err := s.Struct.Binary(op, other.Struct, side)
+	if result == nil || err != nil {
+		return result, err
+	}
+	return &starlarkStruct{result.(*starlarkstruct.Struct)}, nil
+}
+
+// CompareSameType -
+func (s *starlarkStruct) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	other, ok := y.(*starlarkStruct)
+	if !ok {
+		return false, fmt.Errorf("%s %s %s not implemented", s.Type(), op, y.Type())
+	}
+	return s.Struct.CompareSameType(op, other.Struct, depth)
+}
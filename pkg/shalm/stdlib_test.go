@@ -0,0 +1,65 @@
+package shalm
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+)
+
+var _ = Describe("stdlib", func() {
+	thread := &starlark.Thread{Name: "main"}
+
+	evalErr := func(expr string) (starlark.Value, error) {
+		predeclared := stdlib()
+		predeclared["struct"] = starlark.NewBuiltin("struct", makeStruct)
+		return starlark.Eval(thread, "test", expr, predeclared)
+	}
+
+	eval := func(expr string) starlark.Value {
+		value, err := evalErr(expr)
+		Expect(err).NotTo(HaveOccurred())
+		return value
+	}
+
+	It("encodes and decodes json", func() {
+		Expect(eval(`json.encode({"a": [1, "b"]})`)).To(Equal(starlark.String(`{"a":[1,"b"]}`)))
+		Expect(eval(`json.decode('{"a": [1, 1.5]}')["a"][0]`)).To(Equal(starlark.MakeInt(1)))
+		Expect(eval(`json.decode('{"a": [1, 1.5]}')["a"][1]`)).To(Equal(starlark.Float(1.5)))
+	})
+	It("encodes None and reports values, which can't be encoded", func() {
+		Expect(eval(`json.encode({"a": None, "b": [None]})`)).To(Equal(starlark.String(`{"a":null,"b":[null]}`)))
+		Expect(eval(`yaml.encode({"a": None})`)).To(Equal(starlark.String("a: null\n")))
+		_, err := evalErr(`json.encode(len)`)
+		Expect(err).To(MatchError(ContainSubstring("json.encode: cannot encode builtin_function_or_method")))
+		_, err = evalErr(`yaml.encode({"a": struct(b=len)})`)
+		Expect(err).To(MatchError(ContainSubstring("yaml.encode: cannot encode builtin_function_or_method")))
+	})
+
+	It("encodes and decodes yaml", func() {
+		Expect(eval(`yaml.encode({"a": 1})`)).To(Equal(starlark.String("a: 1\n")))
+		Expect(eval(`yaml.decode("a:\n  b: c\n")["a"]["b"]`)).To(Equal(starlark.String("c")))
+	})
+	It("encodes and decodes base64", func() {
+		Expect(eval(`base64.encode("hello")`)).To(Equal(starlark.String("aGVsbG8=")))
+		Expect(eval(`base64.decode("aGVsbG8=")`)).To(Equal(starlark.String("hello")))
+	})
+	It("hashes values", func() {
+		Expect(eval(`hashlib.sha256("hello")`)).To(Equal(starlark.String("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")))
+		Expect(eval(`hashlib.md5("hello")`)).To(Equal(starlark.String("5d41402abc4b2a76b9719d911017c592")))
+	})
+	It("matches regular expressions", func() {
+		Expect(eval(`re.match("^v[0-9]+$", "v12")`)).To(Equal(starlark.True))
+		Expect(eval(`re.sub("[0-9]", "x", "a1b2")`)).To(Equal(starlark.String("axbx")))
+		Expect(eval(`re.find_all("[0-9]+", "a12b3")[1]`)).To(Equal(starlark.String("3")))
+		Expect(eval(`re.split(",", "a,b")[1]`)).To(Equal(starlark.String("b")))
+	})
+	It("parses time", func() {
+		Expect(eval(`time.parse("1970-01-01T00:01:00Z")`)).To(Equal(starlark.MakeInt(60)))
+		Expect(eval(`time.parse(time.now()) <= time.unix()`)).To(Equal(starlark.True))
+	})
+	It("converts structs to json", func() {
+		Expect(eval(`struct(a=1, b=struct(c="d")).to_json()`)).To(Equal(starlark.String(`{"a":1,"b":{"c":"d"}}`)))
+		Expect(eval(`struct(a=1) == struct(a=1)`)).To(Equal(starlark.True))
+		Expect(eval(`(struct(a=1) + struct(b=2)).b`)).To(Equal(starlark.MakeInt(2)))
+	})
+})
@@ -2,9 +2,11 @@ package shalm
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,29 +21,75 @@ var (
 	serializer = json.NewYAMLSerializer(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme)
 )
 
+const (
+	rotatedAtAnnotation = "shalm.kramerul.github.com/rotated-at"
+	checksumAnnotation  = "shalm.kramerul.github.com/checksum"
+	previousKeyPrefix   = "previous-"
+	defaultGracePeriod  = 24 * time.Hour
+)
+
+// credential is a CredentialValue, which is stored inside a kubernetes secret
+type credential interface {
+	CredentialValue
+	secret(namespace string) *corev1.Secret
+}
+
 type userCredential struct {
-	username    string
-	password    string
-	usernameKey string
-	passwordKey string
-	name        string
+	username         string
+	password         string
+	usernameKey      string
+	passwordKey      string
+	name             string
+	fixedPassword    bool
+	rotateAfter      time.Duration
+	gracePeriod      time.Duration
+	rotate           bool
+	rotatedAt        time.Time
+	previousPassword string
+	loaded           bool
 }
 
 var (
-	_ CredentialValue = (*userCredential)(nil)
+	_ credential = (*userCredential)(nil)
 )
 
 func makeUserCredential(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (value starlark.Value, e error) {
-	s := &userCredential{}
+	s := &userCredential{gracePeriod: defaultGracePeriod}
 	s.setDefaultKeys()
+	var rotateAfter, gracePeriod string
 	if err := starlark.UnpackArgs("user_credential", args, kwargs, "name", &s.name,
 		"username_key?", &s.usernameKey, "password_key?", &s.passwordKey,
-		"username?", &s.username, "password?", &s.password); err != nil {
+		"username?", &s.username, "password?", &s.password,
+		"rotate_after?", &rotateAfter, "grace_period?", &gracePeriod); err != nil {
 		return starlark.None, err
 	}
+	s.fixedPassword = s.password != ""
+	var err error
+	if rotateAfter != "" {
+		if s.rotateAfter, err = parseDuration(rotateAfter); err != nil {
+			return starlark.None, fmt.Errorf("user_credential: invalid rotate_after: %s", err.Error())
+		}
+	}
+	if gracePeriod != "" {
+		if s.gracePeriod, err = parseDuration(gracePeriod); err != nil {
+			return starlark.None, fmt.Errorf("user_credential: invalid grace_period: %s", err.Error())
+		}
+	}
 	return s, nil
 }
 
+// parseDuration parses a go duration. Additionally days are supported using the suffix d (e.g. 90d)
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
 // String -
 func (c *userCredential) String() string {
 	buf := new(strings.Builder)
@@ -66,18 +114,14 @@ func (c *userCredential) setDefaultKeys() {
 	}
 }
 
-func createRandomString(length int) string {
-	rand.Seed(time.Now().UnixNano())
-	chars := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
-	var b strings.Builder
-	for i := 0; i < length; i++ {
-		b.WriteRune(chars[rand.Intn(len(chars))])
-	}
-	return b.String()
-}
-
+// GetOrCreate reads username and password from kubernetes. Missing values are created.
+// The password is rotated, if rotate_after has expired or a rotation was requested
 func (c *userCredential) GetOrCreate(k8s K8s) error {
+	if c.loaded {
+		return nil
+	}
 	c.setDefaultKeys()
+	now := time.Now()
 	var buffer bytes.Buffer
 	err := k8s.Get("secret", c.name, &buffer, &K8sOptions{Namespaced: true})
 	if err != nil {
@@ -85,11 +129,16 @@ func (c *userCredential) GetOrCreate(k8s K8s) error {
 			return err
 		}
 		if c.username == "" {
-			c.username = createRandomString(16)
+			if c.username, err = createRandomString(16); err != nil {
+				return err
+			}
 		}
 		if c.password == "" {
-			c.password = createRandomString(16)
+			if c.password, err = createRandomString(16); err != nil {
+				return err
+			}
 		}
+		c.rotatedAt = now
 	} else {
 		var secret corev1.Secret
 
@@ -103,11 +152,46 @@ func (c *userCredential) GetOrCreate(k8s K8s) error {
 		if c.password == "" {
 			c.password = string(secret.Data[c.passwordKey])
 		}
-
+		c.rotatedAt = now
+		if rotatedAt, err := time.Parse(time.RFC3339, secret.Annotations[rotatedAtAnnotation]); err == nil {
+			c.rotatedAt = rotatedAt
+		}
+		if now.Before(c.rotatedAt.Add(c.gracePeriod)) {
+			c.previousPassword = string(secret.Data[previousKeyPrefix+c.passwordKey])
+		}
+		if c.needsRotation(now) {
+			c.previousPassword = c.password
+			if c.password, err = createRandomString(16); err != nil {
+				return err
+			}
+			c.rotatedAt = now
+		}
 	}
+	c.loaded = true
 	return nil
 }
 
+// needsRotation checks, if the password has to be replaced. Passwords given explicitly are never rotated
+func (c *userCredential) needsRotation(now time.Time) bool {
+	if c.fixedPassword {
+		return false
+	}
+	if c.rotate || c.password == "" {
+		return true
+	}
+	return c.rotateAfter > 0 && !now.Before(c.rotatedAt.Add(c.rotateAfter))
+}
+
+// checksum changes whenever username or password changes. It can be used as annotation
+// inside pod templates to roll deployments after rotation
+func (c *userCredential) checksum() string {
+	h := sha256.New()
+	h.Write([]byte(c.username))
+	h.Write([]byte{0})
+	h.Write([]byte(c.password))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (c *userCredential) secret(namespace string) *corev1.Secret {
 	c.setDefaultKeys()
 	data := map[string][]byte{}
@@ -117,14 +201,25 @@ func (c *userCredential) secret(namespace string) *corev1.Secret {
 	if c.password != "" {
 		data[c.passwordKey] = []byte(c.password)
 	}
+	if c.previousPassword != "" {
+		data[previousKeyPrefix+c.passwordKey] = []byte(c.previousPassword)
+	}
+	var annotations map[string]string
+	if c.loaded {
+		annotations = map[string]string{
+			rotatedAtAnnotation: c.rotatedAt.UTC().Format(time.RFC3339),
+			checksumAnnotation:  c.checksum(),
+		}
+	}
 	return &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.String(),
 			Kind:       "Secret",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      c.name,
-			Namespace: namespace,
+			Name:        c.name,
+			Namespace:   namespace,
+			Annotations: annotations,
 		},
 		Type: "Opaque",
 		Data: data,
@@ -158,6 +253,16 @@ func (c *userCredential) Attr(name string) (starlark.Value, error) {
 			return nil, errors.New("password is only available after user_credential is applied")
 		}
 		return starlark.String(c.password), nil
+	case "previous_password":
+		if !c.loaded {
+			return nil, errors.New("previous_password is only available after user_credential is applied")
+		}
+		return starlark.String(c.previousPassword), nil
+	case "checksum":
+		if !c.loaded {
+			return nil, errors.New("checksum is only available after user_credential is applied")
+		}
+		return starlark.String(c.checksum()), nil
 	default:
 		return starlark.None, starlark.NoSuchAttrError(fmt.Sprintf("user_credential has no .%s attribute", name))
 	}
@@ -165,5 +270,5 @@ func (c *userCredential) Attr(name string) (starlark.Value, error) {
 
 // AttrNames -
 func (c *userCredential) AttrNames() []string {
-	return []string{"name", "username", "password"}
+	return []string{"name", "username", "password", "previous_password", "checksum"}
 }